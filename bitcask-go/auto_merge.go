@@ -0,0 +1,124 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mergeWindow 允许自动merge的时间段（一天中的分钟数，左闭右开），start > end 表示跨越零点
+type mergeWindow struct {
+	start, end int
+}
+
+// parseMergeWindow 解析形如 "02:00-05:00" 的时间段，空字符串表示不限制
+func parseMergeWindow(s string) (*mergeWindow, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, errors.New("auto merge window should be like \"02:00-05:00\"")
+	}
+	var minutes [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.New("auto merge window should be like \"02:00-05:00\"")
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	return &mergeWindow{start: minutes[0], end: minutes[1]}, nil
+}
+
+// contains 判断t（本地时间）是否在时间段内
+func (w *mergeWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// autoMerger 后台自动merge的状态
+type autoMerger struct {
+	mu           sync.Mutex
+	runs         uint64        // 已执行merge的次数
+	lastTime     time.Time     // 上一次merge开始的时间
+	lastDuration time.Duration // 上一次merge的耗时
+	lastErr      string        // 上一次merge失败的原因
+}
+
+// runAutoMerger 按配置的间隔检查是否需要merge，直到db关闭
+func (db *DB) runAutoMerger() {
+	defer db.bgWorkers.Done()
+
+	// 配置在Open时已经校验过
+	window, _ := parseMergeWindow(db.options.AutoMergeWindow)
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+	// 数据库关闭时中止正在进行的merge
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-db.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-db.closed:
+			return
+		case now := <-ticker.C:
+			if window.contains(now) && db.needAutoMerge() {
+				db.autoMerge(ctx)
+			}
+		}
+	}
+}
+
+// needAutoMerge 可回收的数据量达到配置的下限，并且上一次merge的结果已经生效时才需要merge
+// merge比例的阈值由Merge自己检查
+func (db *DB) needAutoMerge() bool {
+	db.mu.RLock()
+	reclaimable := db.invalidSize
+	db.mu.RUnlock()
+	if reclaimable < db.options.AutoMergeMinReclaimable {
+		return false
+	}
+	// 旧文件被快照引用时，merge的结果要在下次打开数据库时才会生效，在此之前不再重复merge
+	_, err := os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFile))
+	return os.IsNotExist(err)
+}
+
+func (db *DB) autoMerge(ctx context.Context) {
+	start := time.Now()
+	err := db.MergeWithContext(ctx, DefaultMergeOptions)
+	// 因为数据库关闭而中止的merge不计入统计
+	if err == ErrMergeRatioUnreached || err == ErrMergeIsProgress || ctx.Err() != nil {
+		return
+	}
+	var errMsg string
+	if err != nil {
+		log.Printf("auto merge failed: %v", err)
+		errMsg = err.Error()
+	}
+
+	db.merger.mu.Lock()
+	db.merger.runs++
+	db.merger.lastTime = start
+	db.merger.lastDuration = time.Since(start)
+	db.merger.lastErr = errMsg
+	db.merger.mu.Unlock()
+}
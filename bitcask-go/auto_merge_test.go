@@ -0,0 +1,88 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseMergeWindow(t *testing.T) {
+	w, err := parseMergeWindow("")
+	assert.Nil(t, err)
+	assert.True(t, w.contains(time.Now()))
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2023, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	w, err = parseMergeWindow("02:00-05:00")
+	assert.Nil(t, err)
+	assert.True(t, w.contains(at(2, 0)))
+	assert.True(t, w.contains(at(4, 59)))
+	assert.False(t, w.contains(at(5, 0)))
+	assert.False(t, w.contains(at(23, 0)))
+
+	// 跨越零点
+	w, err = parseMergeWindow("23:30 - 01:00")
+	assert.Nil(t, err)
+	assert.True(t, w.contains(at(23, 45)))
+	assert.True(t, w.contains(at(0, 30)))
+	assert.False(t, w.contains(at(1, 0)))
+	assert.False(t, w.contains(at(12, 0)))
+
+	for _, s := range []string{"02:00", "2am-5am", "02:00-25:00"} {
+		_, err = parseMergeWindow(s)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-auto-merge"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeRatioThreshold = 0
+	opts.AutoMergeInterval = 20 * time.Millisecond
+	opts.AutoMergeMinReclaimable = 1024
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	_ = os.RemoveAll(db.getMergePath())
+
+	// 可回收的数据量不够，不会merge
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, uint64(0), db.Stat().AutoMergeRuns)
+
+	for i := 0; i < 500; i++ {
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return db.Stat().AutoMergeRuns > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	stat := db.Stat()
+	assert.Equal(t, "", stat.LastMergeError)
+	assert.False(t, stat.LastMergeTime.IsZero())
+	assert.True(t, stat.LastMergeDuration > 0)
+
+	// merge立即生效，可回收的数据已经清理掉，不会重复merge
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, stat.AutoMergeRuns, db.Stat().AutoMergeRuns)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.AutoMergeInterval = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(500), db.Stat().KeyNum)
+	val, err := db.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	_, err = Open(Options{DirPath: "/tmp/kv/DB-auto-merge-invalid", AutoMergeWindow: "2-5"})
+	assert.NotNil(t, err)
+}
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
@@ -85,17 +86,35 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 加锁保证事务提交的串行化
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	records := make([]*data.LogRecord, 0, len(wb.pendingWrites))
+	for _, record := range wb.pendingWrites {
+		records = append(records, record)
+	}
+	// 在db锁内提交保证事务提交的串行化，需要持久化时与其他并发的同步写操作一起组提交
+	syncWrites := wb.options.SyncWrites || wb.db.syncOnWrite()
+	if err := wb.db.commitWrite(syncWrites, func() error {
+		return wb.db.writeTransactionWithoutLock(records, wb.options.SyncWrites)
+	}); err != nil {
+		return err
+	}
 
+	// 最后清空暂存数据结构，方便下一次commit
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// writeTransactionWithoutLock 以一个事务的形式写入一批记录：所有记录带上同一个事务序列号，最后写入一条事务完成的标识，
+// 然后再统一更新内存索引
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) writeTransactionWithoutLock(records []*data.LogRecord, syncWrites bool) error {
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// 写入数据。 先写入数据文件，全部写完之后，再将位置信息存入内存索引
 	posTmp := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecordWithoutLock( // 上面已经给db上过锁了
+	for _, record := range records {
+		logRecordPos, err := db.appendLogRecordWithoutLock( // 调用方已经给db上过锁了
 			&data.LogRecord{
 				Key:   encodeKeyWithSeqNo(record.Key, seqNo),
 				Value: record.Value,
@@ -113,38 +132,44 @@ func (wb *WriteBatch) Commit() error {
 		Key:  encodeKeyWithSeqNo(transactionFinishKey, seqNo),
 		Type: data.TransactionFinished,
 	}
-	_, err := wb.db.appendLogRecordWithoutLock(fin)
+	finPos, err := db.appendLogRecordWithoutLock(fin)
 	if err != nil {
 		return err
 	}
+	// 事务完成的标识本身也是merge时可以回收的数据
+	db.addInvalidSize(finPos)
 
-	// 根据配置决定是否进行持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		err2 := wb.db.activeFile.SyncFile()
+	// 根据配置决定是否进行持久化（组提交时由leader统一持久化）
+	if syncWrites && !db.deferSync && db.activeFile != nil {
+		err2 := db.syncActiveFileWithoutLock()
 		if err2 != nil {
 			return err2
 		}
 	}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := posTmp[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
-			wb.db.invalidSize += int64(pos.Size) // ???
-		}
-		if oldPos != nil {
-			wb.db.invalidSize += int64(oldPos.Size)
+	// 更新内存索引（磁盘索引在一个bolt事务中更新整个批次）
+	if err = db.updateIndex(func(idx index.Writer) error {
+		for _, record := range records {
+			pos := posTmp[string(record.Key)]
+			var oldPos *data.LogRecordPos
+			if record.Type == data.LogRecordNormal {
+				oldPos = idx.Put(record.Key, pos)
+			}
+			if record.Type == data.LogRecordDeleted {
+				oldPos, _ = idx.Delete(record.Key)
+				db.addInvalidSize(pos) // ???
+			}
+			if oldPos != nil {
+				db.addInvalidSize(oldPos)
+			}
 		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, record := range records {
+		db.markModifiedWithoutLock(record.Key, seqNo)
 	}
-
-	// 最后清空暂存数据结构，方便下一次commit
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
 	return nil
 }
 
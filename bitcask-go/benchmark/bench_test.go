@@ -11,6 +11,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -99,6 +100,70 @@ func Benchmark_goCaskDBRandom(b *testing.B) {
 	//println(num)
 }
 
+// Benchmark_goCaskDBSyncPut 单个写入者，每次写入都持久化
+func Benchmark_goCaskDBSyncPut(b *testing.B) {
+	db := getGoCaskDBWithSync()
+	value := utils.RandomValue(valueLen)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(b, err)
+	}
+}
+
+// Benchmark_goCaskDBSyncPutParallel 多个并发写入者，每次写入都持久化，通过组提交共用fsync
+func Benchmark_goCaskDBSyncPutParallel(b *testing.B) {
+	db := getGoCaskDBWithSync()
+	// RandomValue不是并发安全的，提前生成value
+	value := utils.RandomValue(valueLen)
+	var num int64
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := utils.GetTestKey(int(atomic.AddInt64(&num, 1)))
+			if err := db.Put(key, value); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// Benchmark_goCaskDBSyncBatchParallel 多个并发写入者，每个批量写入10条数据并持久化
+func Benchmark_goCaskDBSyncBatchParallel(b *testing.B) {
+	db := getGoCaskDBWithSync()
+	value := utils.RandomValue(valueLen)
+	var num int64
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wb := db.NewWriteBatch(goCaskDB.DefaultWriteBatchOptions)
+			for i := 0; i < 10; i++ {
+				key := utils.GetTestKey(int(atomic.AddInt64(&num, 1)))
+				if err := wb.PendingPut(key, value); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+			if err := wb.Commit(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
 func Benchmark_goLevelDBRandom(b *testing.B) {
 	// 以p的概率 随机读写
 	// 加上b.StopTimer()
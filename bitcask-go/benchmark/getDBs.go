@@ -23,6 +23,23 @@ func getGoCaskDB() *goCaskDB.DB {
 	return db
 }
 
+// getGoCaskDBWithSync 每次写入都立即持久化的goCaskDB
+func getGoCaskDBWithSync() *goCaskDB.DB {
+	opt := goCaskDB.DefaultOptions
+	_ = os.MkdirAll("/tmp/bench_tmp", os.ModePerm)
+	dir, _ := os.MkdirTemp("/tmp/bench_tmp", "CaskSync")
+	opt.DirPath = dir
+	opt.SyncWrites = true
+	opt.IndexType = goCaskDB.SkipList
+
+	db, err := goCaskDB.Open(opt)
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
 func getBoltDB() *bolt.DB {
 	dir := "/tmp/bench_tmp/bolt_db.bolt"
 	opts := bolt.DefaultOptions
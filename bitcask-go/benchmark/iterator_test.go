@@ -0,0 +1,142 @@
+package benchmark
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+/*
+索引迭代器的内存占用
+	迭代器按批次遍历索引，不再把整个索引拷贝到数组中，
+	live-bytes 表示遍历到一半时迭代器额外占用的堆内存，应当与索引大小无关。
+*/
+
+const iteratorKeyNum = 200000
+
+func benchmarkIndexIterator(b *testing.B, tp index.IndexType, reverse bool) {
+	idx, _ := index.NewIndexer(tp, "")
+	for i := 0; i < iteratorKeyNum; i++ {
+		idx.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	var stats runtime.MemStats
+	var live uint64
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		before := stats.HeapAlloc
+
+		iter := idx.Iterator(reverse)
+		iter.Rewind()
+		for j := 0; j < iteratorKeyNum/2 && iter.IsValid(); j++ {
+			iter.Next()
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > before {
+			live += stats.HeapAlloc - before
+		}
+		runtime.KeepAlive(iter)
+		iter.Close()
+	}
+	b.ReportMetric(float64(live)/float64(b.N), "live-bytes")
+}
+
+func Benchmark_BTreeIterator(b *testing.B) {
+	benchmarkIndexIterator(b, index.Btree, false)
+}
+
+func Benchmark_BTreeIteratorReverse(b *testing.B) {
+	benchmarkIndexIterator(b, index.Btree, true)
+}
+
+func Benchmark_SkipListIterator(b *testing.B) {
+	benchmarkIndexIterator(b, index.SkipList, false)
+}
+
+func Benchmark_SkipListIteratorReverse(b *testing.B) {
+	benchmarkIndexIterator(b, index.SkipList, true)
+}
+
+func Benchmark_ARTIterator(b *testing.B) {
+	benchmarkIndexIterator(b, index.ART, false)
+}
+
+func Benchmark_ARTIteratorReverse(b *testing.B) {
+	benchmarkIndexIterator(b, index.ART, true)
+}
+
+/*
+长 key 下 Seek 与反向遍历的耗时
+	goART 和跳表没有能直接定位或反向走的游标，Seek（goART）和反向遍历（两者）按前缀逐个字节拆分后逐段取出，
+	每取一批最多要做 256 × key长度 次前缀遍历，key 越长越慢；BTree 作为对照。
+*/
+
+const longKeyNum = 20000
+
+func getLongTestKey(i int) []byte {
+	return append(bytes.Repeat([]byte("k"), 100), utils.GetTestKey(i)...)
+}
+
+func newLongKeyIndex(tp index.IndexType) index.Indexer {
+	idx, _ := index.NewIndexer(tp, "")
+	for i := 0; i < longKeyNum; i++ {
+		idx.Put(getLongTestKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	return idx
+}
+
+func benchmarkLongKeyReverse(b *testing.B, tp index.IndexType) {
+	idx := newLongKeyIndex(tp)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter := idx.Iterator(true)
+		for iter.Rewind(); iter.IsValid(); iter.Next() {
+		}
+		iter.Close()
+	}
+}
+
+func benchmarkLongKeySeek(b *testing.B, tp index.IndexType) {
+	idx := newLongKeyIndex(tp)
+	iter := idx.Iterator(false)
+	defer iter.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter.Seek(getLongTestKey(i % longKeyNum))
+		if !iter.IsValid() {
+			b.Fatal("seek failed")
+		}
+	}
+}
+
+func Benchmark_BTreeIteratorReverse_LongKey(b *testing.B) {
+	benchmarkLongKeyReverse(b, index.Btree)
+}
+
+func Benchmark_SkipListIteratorReverse_LongKey(b *testing.B) {
+	benchmarkLongKeyReverse(b, index.SkipList)
+}
+
+func Benchmark_ARTIteratorReverse_LongKey(b *testing.B) {
+	benchmarkLongKeyReverse(b, index.ART)
+}
+
+func Benchmark_BTreeIteratorSeek_LongKey(b *testing.B) {
+	benchmarkLongKeySeek(b, index.Btree)
+}
+
+func Benchmark_SkipListIteratorSeek_LongKey(b *testing.B) {
+	benchmarkLongKeySeek(b, index.SkipList)
+}
+
+func Benchmark_ARTIteratorSeek_LongKey(b *testing.B) {
+	benchmarkLongKeySeek(b, index.ART)
+}
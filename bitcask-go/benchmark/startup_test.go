@@ -0,0 +1,66 @@
+package benchmark
+
+import (
+	goCaskDB "bitcask-go"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+/*
+启动时的索引加载
+	准备一个有多个旧数据文件的目录（不生成hint文件），比较顺序加载与并发加载时Open的耗时
+*/
+
+const startupKeyNum = 500000
+
+func prepareStartupDB(b *testing.B) goCaskDB.Options {
+	opts := goCaskDB.DefaultOptions
+	opts.DirPath = filepath.Join(os.TempDir(), "bench_startup")
+	opts.DataFileSize = 8 * 1024 * 1024
+	opts.DataFileHint = false
+
+	// 目录已经存在时直接复用
+	if _, err := os.Stat(opts.DirPath); err == nil {
+		return opts
+	}
+	db, err := goCaskDB.Open(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < startupKeyNum; i++ {
+		if err = db.Put(utils.GetTestKey(i%(startupKeyNum/2)), utils.RandomValue(128)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return opts
+}
+
+func benchmarkOpen(b *testing.B, workers int) {
+	opts := prepareStartupDB(b)
+	opts.IndexLoadWorkers = workers
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db, err := goCaskDB.Open(opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		_ = db.Close()
+		b.StartTimer()
+	}
+}
+
+func Benchmark_OpenSerial(b *testing.B) {
+	benchmarkOpen(b, 0)
+}
+
+func Benchmark_OpenParallel(b *testing.B) {
+	benchmarkOpen(b, runtime.NumCPU())
+}
@@ -0,0 +1,73 @@
+package bitcask_go
+
+import "bytes"
+
+/*
+	cas.go 条件写入。检查和写入在同一个db.mu临界区内完成，避免先Get再Put之间被其他写入插入。
+	读取value需要访问数据文件，与索引类型无关，所以对所有索引类型都适用。
+*/
+
+// CompareAndSwap 当key存在且value等于oldValue时，将其更新为newValue，过期时间保持不变。返回是否发生了更新
+func (db *DB) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.getLiveValueWithoutLock(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(value, oldValue) {
+		return false, nil
+	}
+	if err = db.putWithoutLock(key, newValue, db.index.Get(key).Expire); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 当key不存在（或已过期）时写入一个永不过期的键值对，已过期的key原来的过期时间不会被沿用。返回是否发生了写入
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired() {
+		return false, nil
+	}
+	if err := db.putWithoutLock(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfEquals 当key存在且value等于给定的value时删除。返回是否发生了删除
+func (db *DB) DeleteIfEquals(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current, err := db.getLiveValueWithoutLock(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, value) {
+		return false, nil
+	}
+	if err = db.deleteWithoutLock(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
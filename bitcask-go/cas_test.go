@@ -0,0 +1,144 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_ConditionalWrites(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, ART, SkipList, Hash, BPTree} {
+		t.Run(fmt.Sprintf("index-%d", indexType), func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = "/tmp/kv/DB-cas"
+			opts.IndexType = indexType
+			db, err := Open(opts)
+			defer destroyDB(db)
+			assert.Nil(t, err)
+			assert.NotNil(t, db)
+
+			key := utils.GetTestKey(1)
+			// key 不存在
+			ok, err := db.CompareAndSwap(key, []byte("a"), []byte("b"))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			ok, err = db.DeleteIfEquals(key, []byte("a"))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			ok, err = db.PutIfAbsent(key, []byte("a"))
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			ok, err = db.PutIfAbsent(key, []byte("x"))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			// 旧值不相等时不更新
+			ok, err = db.CompareAndSwap(key, []byte("x"), []byte("b"))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			ok, err = db.CompareAndSwap(key, []byte("a"), []byte("b"))
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			val, err := db.Get(key)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("b"), val)
+
+			ok, err = db.DeleteIfEquals(key, []byte("a"))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			ok, err = db.DeleteIfEquals(key, []byte("b"))
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			_, err = db.Get(key)
+			assert.Equal(t, ErrKeyNotFound, err)
+		})
+	}
+}
+
+func TestDB_CompareAndSwapCounter(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-cas"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key := []byte("counter")
+	err = db.Put(key, []byte("0"))
+	assert.Nil(t, err)
+
+	// 并发地用CAS自增计数器，不会丢失更新
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					old, err := db.Get(key)
+					assert.Nil(t, err)
+					n, _ := strconv.Atoi(string(old))
+					ok, err := db.CompareAndSwap(key, old, []byte(strconv.Itoa(n+1)))
+					assert.Nil(t, err)
+					if ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), val)
+}
+
+func TestDB_CompareAndSwapWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-cas-ttl"
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	// CAS 保留原来的过期时间
+	key := utils.GetTestKey(1)
+	assert.Nil(t, db.PutWithTTL(key, []byte("a"), time.Hour))
+	ttl, err := db.TTL(key)
+	assert.Nil(t, err)
+	ok, err := db.CompareAndSwap(key, []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	newTTL, err := db.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, newTTL > 0 && newTTL <= ttl, newTTL)
+
+	// 重启后依然保留
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	newTTL, err = db.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, newTTL > 0 && newTTL <= ttl, newTTL)
+
+	// 过期之后CAS不生效，PutIfAbsent写入的key永不过期
+	assert.Nil(t, db.PutWithTTL(key, []byte("c"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	ok, err = db.CompareAndSwap(key, []byte("c"), []byte("d"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.PutIfAbsent(key, []byte("d"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	newTTL, err = db.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), newTTL)
+}
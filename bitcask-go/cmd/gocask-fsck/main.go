@@ -0,0 +1,141 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// keyFlags 可以重复出现的 -key id:hex 参数，轮换过密钥的数据目录需要提供所有用到的密钥
+type keyFlags struct {
+	ids  []uint32
+	keys map[uint32][]byte
+}
+
+func (k *keyFlags) String() string {
+	var ids []string
+	for _, id := range k.ids {
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(ids, ",")
+}
+
+func (k *keyFlags) Set(value string) error {
+	idStr, keyHex, ok := strings.Cut(value, ":")
+	if !ok {
+		return errors.New("expected id:hex")
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid key id: %v", err)
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	if _, ok = k.keys[uint32(id)]; ok {
+		return fmt.Errorf("duplicate key id %d", id)
+	}
+	if k.keys == nil {
+		k.keys = make(map[uint32][]byte)
+	}
+	k.ids = append(k.ids, uint32(id))
+	k.keys[uint32(id)] = key
+	return nil
+}
+
+// gocask-fsck 不打开数据库，离线检查数据目录，可选地把修复后的数据写到另一个目录
+// 退出码：0 没有问题，1 检查出了问题，2 无法完成检查
+func main() {
+	repairDir := flag.String("repair", "", "write a repaired copy of the data directory to this (empty) directory")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	var keys keyFlags
+	flag.Var(&keys, "key", "id:hex of an AES key of an encrypted data directory, repeat for every key the files use")
+	keyID := flag.Uint("key-id", 0, "id of the key that encrypts the repaired copy, the first -key if not set")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <data dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keyIDSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "key-id" {
+			keyIDSet = true
+		}
+	})
+	if keyIDSet {
+		if _, ok := keys.keys[uint32(*keyID)]; !ok {
+			fmt.Fprintf(flag.CommandLine.Output(), "-key-id %d has no matching -key\n", *keyID)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
+	opts := bitcask.VerifyOptions{RepairDir: *repairDir}
+	if len(keys.ids) > 0 {
+		currentID := keys.ids[0]
+		if keyIDSet {
+			currentID = uint32(*keyID)
+		}
+		opts.Encryption = &bitcask.KeyRing{CurrentID: currentID, Keys: keys.keys}
+	}
+
+	report, err := bitcask.VerifyWithOptions(flag.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify failed: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		printReport(report)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *bitcask.VerifyReport) {
+	fmt.Printf("%s: %d data files\n", report.Dir, len(report.Files))
+	for _, file := range report.Files {
+		hint := "no hint"
+		if file.HasHint && file.HintPassed {
+			hint = "hint ok"
+		} else if file.HasHint {
+			hint = "hint MISMATCH"
+		}
+		fmt.Printf("  %09d.data  v%d  %d records  %d/%d bytes valid  %s\n",
+			file.Fid, file.Version, file.Records, file.ValidSize, file.Size, hint)
+	}
+
+	if report.OK() {
+		fmt.Println("no problems found")
+	} else {
+		fmt.Printf("%d problems found:\n", len(report.Problems))
+		for _, problem := range report.Problems {
+			if problem.Offset >= 0 {
+				fmt.Printf("  [%s] %s @%d: %s\n", problem.Kind, problem.File, problem.Offset, problem.Message)
+			} else {
+				fmt.Printf("  [%s] %s: %s\n", problem.Kind, problem.File, problem.Message)
+			}
+		}
+	}
+
+	if report.RepairDir != "" {
+		fmt.Printf("repaired copy with %d records written to %s\n", report.RepairedRecords, report.RepairDir)
+	}
+}
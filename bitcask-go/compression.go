@@ -0,0 +1,41 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// compressRecord 按当前配置的压缩方式重新压缩记录的value（merge时读出的旧记录可能是用别的方式压缩的）
+// 太短的value，以及压缩后没有变小的value不压缩
+func (db *DB) compressRecord(record *data.LogRecord) error {
+	if record.Type != data.LogRecordNormal {
+		return nil
+	}
+	codec := db.options.Compression
+	if record.Codec == codec {
+		return nil
+	}
+
+	value, err := data.DecompressValue(record.Codec, record.Value)
+	if err != nil {
+		return err
+	}
+	record.Value, record.Codec = value, data.CodecNone
+	if codec == data.CodecNone || len(value) < db.options.CompressionMinSize {
+		return nil
+	}
+	compressed, err := data.CompressValue(codec, value)
+	if err != nil {
+		return err
+	}
+	if len(compressed) < len(value) {
+		record.Value, record.Codec = compressed, codec
+	}
+	return nil
+}
+
+// compressionRatio 本次打开以来写入的value压缩前与压缩后的大小之比，还没有写入过value时为0
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) compressionRatio() float64 {
+	if db.storedValueSize == 0 {
+		return 0
+	}
+	return float64(db.rawValueSize) / float64(db.storedValueSize)
+}
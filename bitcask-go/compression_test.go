@@ -0,0 +1,167 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// jsonValue 容易压缩的大value
+func jsonValue(i int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf(`{"id":%d,"name":"bitcask-go","tags":["kv","storage"]},`, i)), 40)
+}
+
+// codecsOnDisk 统计数据文件中每种压缩方式的普通记录数量
+func codecsOnDisk(t *testing.T, db *DB) map[data.Codec]int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	files := []*data.File{db.activeFile}
+	for _, file := range db.olderFiles {
+		files = append(files, file)
+	}
+	codecs := make(map[data.Codec]int)
+	for _, file := range files {
+		var offset int64
+		for {
+			record, size, err := file.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			if record.Type == data.LogRecordNormal {
+				codecs[record.Codec]++
+			}
+			offset += size
+		}
+	}
+	return codecs
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-compression"
+	opts.DataFileSize = 8 * 1024
+	opts.MergeRatioThreshold = 0
+	opts.Compression = ZstdCompression
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), jsonValue(i)))
+	}
+	// 太短的value不压缩
+	small := []byte("small value")
+	assert.Nil(t, db.Put([]byte("small"), small))
+
+	assert.Equal(t, map[data.Codec]int{data.CodecZstd: 200, data.CodecNone: 1}, codecsOnDisk(t, db))
+	assert.True(t, db.Stat().CompressionRatio > 5)
+	assert.True(t, db.Stat().OccupiedDiscSize < int64(len(jsonValue(0))*200))
+
+	// Get、迭代器、快照都能读到原始的value
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonValue(10), val)
+	val, err = db.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+	iter := db.NewIterator(IteratorOptions{Prefix: utils.GetTestKey(10)})
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err = iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, jsonValue(10), val)
+	}
+	iter.Close()
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	val, err = snap.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonValue(20), val)
+	snap.Release()
+
+	// 换成snappy后，新旧两种压缩方式的记录混在一起也能正确读出
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Compression = SnappyCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), db.Stat().CompressionRatio)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), jsonValue(i+1)))
+	}
+	assert.Equal(t, map[data.Codec]int{data.CodecZstd: 200, data.CodecSnappy: 100, data.CodecNone: 1}, codecsOnDisk(t, db))
+	for i := 0; i < 200; i++ {
+		val, err = db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if i < 100 {
+			assert.Equal(t, jsonValue(i), val)
+		} else {
+			assert.Equal(t, jsonValue(i+1), val)
+		}
+	}
+
+	// merge时旧记录按当前的压缩方式重新压缩
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, map[data.Codec]int{data.CodecSnappy: 200, data.CodecNone: 1}, codecsOnDisk(t, db))
+	val, err = db.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonValue(50), val)
+
+	// 关闭压缩后依然能读出压缩过的记录
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Compression = NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(150))
+	assert.Nil(t, err)
+	assert.Equal(t, jsonValue(151), val)
+
+	_, err = Open(Options{DirPath: "/tmp/kv/DB-compression-invalid", Compression: 3})
+	assert.NotNil(t, err)
+}
+
+func TestDB_IncrementalMerge_Compression(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-incremental-merge-compression"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	opts.IncrementalMerge = true
+	opts.IncrementalMergeMaxFiles = 0
+	opts.MergeRatioThreshold = 0.1
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), jsonValue(i)))
+	}
+	for i := 0; i < 200; i += 5 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重写文件时压缩其中的记录
+	opts.Compression = ZstdCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	codecs := codecsOnDisk(t, db)
+	assert.True(t, codecs[data.CodecZstd] > 0)
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i%5 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, jsonValue(i), val)
+	}
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"errors"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"sync"
+)
+
+// Codec LogRecord中value的压缩方式，记录在header的Type字段中
+type Codec = byte
+
+const (
+	// CodecNone value没有压缩
+	CodecNone Codec = iota
+	// CodecSnappy snappy压缩
+	CodecSnappy
+	// CodecZstd zstd压缩
+	CodecZstd
+)
+
+// logRecordCodecShift Type字段中第5、6位保存value的压缩方式，全为0时表示没有压缩，与之前写入的记录兼容
+const logRecordCodecShift = 5
+
+const logRecordCodecMask byte = 3 << logRecordCodecShift
+
+var ErrUnknownCodec = errors.New("unknown value compression codec")
+
+// zstd的编码器和解码器可以被多个goroutine同时使用，第一次用到时再创建
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func initZstd() {
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+}
+
+// CompressValue 用codec压缩value
+func CompressValue(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return value, nil
+	case CodecSnappy:
+		return snappy.Encode(nil, value), nil
+	case CodecZstd:
+		zstdOnce.Do(initZstd)
+		return zstdEncoder.EncodeAll(value, nil), nil
+	default:
+		return nil, ErrUnknownCodec
+	}
+}
+
+// DecompressValue 解压用codec压缩过的value
+func DecompressValue(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return value, nil
+	case CodecSnappy:
+		return snappy.Decode(nil, value)
+	case CodecZstd:
+		zstdOnce.Do(initZstd)
+		return zstdDecoder.DecodeAll(value, nil)
+	default:
+		return nil, ErrUnknownCodec
+	}
+}
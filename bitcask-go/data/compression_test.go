@@ -0,0 +1,26 @@
+package data
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompressValue(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","tags":["kv","storage"]}`), 100)
+	for _, codec := range []Codec{CodecNone, CodecSnappy, CodecZstd} {
+		compressed, err := CompressValue(codec, value)
+		assert.Nil(t, err)
+		if codec != CodecNone {
+			assert.True(t, len(compressed) < len(value))
+		}
+		decompressed, err := DecompressValue(codec, compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	_, err := CompressValue(3, value)
+	assert.Equal(t, ErrUnknownCodec, err)
+	_, err = DecompressValue(CodecSnappy, []byte("not snappy"))
+	assert.NotNil(t, err)
+}
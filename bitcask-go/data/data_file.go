@@ -6,12 +6,19 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
+	"os"
 	"path/filepath"
 )
 
+var (
+	ErrInvalidCRC      = errors.New("invalid CRC value, log record may be corrupted")
+	ErrRecordTruncated = errors.New("the log record exceeds the end of the file, it is incomplete or its header is corrupted")
+)
+
 const (
 	FileSuffix        = ".data"
 	HintFileName      = "hint"
+	HintFileSuffix    = ".hint"
 	MergeFinishedFile = "merged-mark"
 )
 
@@ -19,6 +26,9 @@ type File struct {
 	Fid         uint32        // 文件id
 	WriteOffset int64         // 文件写到了什么位置(主要用于活跃文件)
 	IOManager   fio.IOManager // 数据读写操作的抽象接口
+	Header      FileHeader    // 文件头，Version为0表示没有文件头的旧文件
+	keys        KeyProvider   // 加密文件的密钥来源，为nil表示不加密
+	cipher      *fileCipher   // 文件加密时使用的AES-GCM
 }
 
 // GetDataFileName 得到dirPath目录下数据文件的文件名称（即fid）
@@ -39,24 +49,46 @@ func OpenHintFile(dirPath string) (*File, error) {
 	return NewFile(fileName, 0, fio.StandardFIO)
 }
 
+// GetDataFileHintName 得到dirPath目录下fileId号数据文件对应的hint文件的名称
+func GetDataFileHintName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileSuffix)
+}
+
+// OpenDataFileHint 打开fileId号数据文件对应的hint文件
+func OpenDataFileHint(dirPath string, fileId uint32) (*File, error) {
+	return NewFile(GetDataFileHintName(dirPath, fileId), fileId, fio.ReadOnlyFIO)
+}
+
 // OpenMergeFinishedFile 从dirPath打开一个merge完成的标识文件
 func OpenMergeFinishedFile(dirPath string) (*File, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFile)
 	return NewFile(fileName, 0, fio.StandardFIO)
 }
 
+// NewFile 打开文件，以StandardFIO打开的空文件会写入文件头，已有的文件校验文件头
+// 之后读写使用的偏移量都不包含文件头
 func NewFile(fileName string, fileId uint32, ioType fio.FileIOType) (*File, error) {
+	return newFile(fileName, fileId, ioType, nil)
+}
+
+func newFile(fileName string, fileId uint32, ioType fio.FileIOType, keys KeyProvider) (*File, error) {
 	// 初始化IO管理接口
 	ioManager, err := fio.NewIOManager(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
 
-	return &File{
+	file := &File{
 		Fid:         fileId,
 		WriteOffset: 0,
 		IOManager:   ioManager,
-	}, nil
+		keys:        keys,
+	}
+	if err = file.initHeader(ioType == fio.StandardFIO); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
 }
 
 func (f *File) Close() error {
@@ -64,6 +96,7 @@ func (f *File) Close() error {
 }
 
 // ReadLogRecord 根据offset读取记录
+// crc校验或者解密失败时同样返回header中记录的长度，调用方据此判断损坏的记录是否一直延伸到文件末尾
 func (f *File) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	// Go语言中，读取超过文件大小会返回EOF错误，而当记录被deleted时，记录的header长度会小于maxLogRecordHeaderSize，
@@ -98,13 +131,19 @@ func (f *File) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	ks, vs := int64(header.keySize), int64(header.valueSize)
 
 	recordSize := headerSize + ks + vs
-	logRecord := &LogRecord{Type: header.recordType}
+	// 记录超出了文件末尾（没有写完，或者header本身已经损坏），不按header中的长度分配内存
+	// 不能当作EOF：旧文件中间的长度损坏时，后面的数据会被悄悄丢掉
+	if offset+recordSize > fileSize {
+		return nil, 0, ErrRecordTruncated
+	}
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire, Codec: header.codec}
 
 	// 读取实际存储的key和value
+	var kvBuf []byte
 	if ks > 0 || vs > 0 {
-		kvBuf, err2 := f.readNBytes(ks+vs, offset+headerSize)
-		if err2 != nil {
-			return nil, 0, err2
+		kvBuf, err = f.readNBytes(ks+vs, offset+headerSize)
+		if err != nil {
+			return nil, 0, err
 		}
 		logRecord.Key = kvBuf[:ks]
 		logRecord.Value = kvBuf[ks:]
@@ -114,12 +153,30 @@ func (f *File) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 存数据时计算一次crc并保存（crc1），取出数据后再根据取出的数据计算一次crc（记为crc2），最后判断两个数字是否相等
 	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
 	if crc != header.crc {
-		return nil, 0, errors.New("invalid CRC value, log record may be corrupted")
+		return nil, recordSize, ErrInvalidCRC
+	}
+
+	// 加密的记录在crc校验通过后再解密
+	if header.encrypted {
+		if f.cipher == nil {
+			return nil, 0, ErrEncryptionKeyRequired
+		}
+		plain, err := f.cipher.open(kvBuf, headerBuf[crc32.Size:headerSize])
+		if err != nil {
+			return nil, recordSize, err
+		}
+		logRecord.Key = plain[:ks]
+		logRecord.Value = plain[ks:]
 	}
 
 	return logRecord, recordSize, nil
 }
 
+// EncodeLogRecord 按文件是否加密对记录编码，写入该文件的记录都要用它编码
+func (f *File) EncodeLogRecord(record *LogRecord) ([]byte, int64) {
+	return encodeLogRecord(record, f.cipher)
+}
+
 func (f *File) Write(buf []byte) error {
 	writeSize, err := f.IOManager.Write(buf)
 	if err != nil {
@@ -146,10 +203,42 @@ func (f *File) WriteHintFile(key []byte, pos *LogRecordPos) error {
 		Key:   key,
 		Value: EncodeLogRecordPos(pos), // value 是对位置信息编码后的一条记录（fid， offset）
 	}
-	encodedRecord, _ := EncodeLogRecord(record)
+	encodedRecord, _ := f.EncodeLogRecord(record)
+	return f.Write(encodedRecord)
+}
+
+// WriteDataFileHint 将数据文件中一条记录的索引信息写入该数据文件的hint文件
+// 与merge的hint文件不同，这里保留记录原本的key（带事务序列号）、类型和过期时间，加载时与扫描数据文件的效果相同
+func (f *File) WriteDataFileHint(record *LogRecord, pos *LogRecordPos) error {
+	hintRecord := &LogRecord{
+		Key:    record.Key,
+		Value:  EncodeLogRecordPos(pos),
+		Type:   record.Type,
+		Expire: record.Expire,
+	}
+	encodedRecord, _ := f.EncodeLogRecord(hintRecord)
 	return f.Write(encodedRecord)
 }
 
+// Truncate 把dirPath目录下的数据文件截断到size（不包含文件头），之后以标准IO重新打开
+func (f *File) Truncate(dirPath string, size int64) error {
+	fileName := GetDataFileName(dirPath, f.Fid)
+	logicalSize, err := f.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	// 文件头的长度 = 文件实际大小 - 上层看到的大小
+	if err = os.Truncate(fileName, info.Size()-logicalSize+size); err != nil {
+		return err
+	}
+	f.WriteOffset = size
+	return f.SetIOManager(dirPath, fio.StandardFIO)
+}
+
 // SetIOManager 更改当前文件的io类型
 func (f *File) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	if err := f.IOManager.Close(); err != nil {
@@ -160,5 +249,6 @@ func (f *File) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 		return err
 	}
 	f.IOManager = ioManager
-	return nil
+	// 重新打开后文件头需要再跳过一次；之前以mmap打开的空文件在这里写入文件头
+	return f.initHeader(ioType == fio.StandardFIO)
 }
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// encryptionMagic 加密头以它开头，紧跟在文件头之后（版本0的文件在文件开头）
+var encryptionMagic = []byte("GCKE")
+
+const (
+	encryptionNonceSize = 12
+	encryptionTagSize   = 16
+	// encryptionHeaderSize 加密头：magic(4) + 密钥id(4) + nonce(12) + 认证标签(16)
+	// 认证标签是用文件的密钥对空明文加密得到的，打开文件时用它判断密钥是否正确
+	encryptionHeaderSize = 4 + 4 + encryptionNonceSize + encryptionTagSize
+)
+
+// logRecordEncryptedFlag Type字段的第4位，置位时表示key和value是加密后保存的
+const logRecordEncryptedFlag byte = 1 << 4
+
+var (
+	ErrEncryptionKeyRequired = errors.New("the file is encrypted but no encryption key provider is configured")
+	ErrWrongEncryptionKey    = errors.New("wrong encryption key, failed to authenticate the file header")
+	ErrEncryptionKeyNotFound = errors.New("encryption key not found in the key provider")
+	ErrDecryptionFailed      = errors.New("failed to decrypt the log record")
+)
+
+// KeyProvider 提供加密用的密钥，每个密钥用一个id标识，id保存在文件头中
+// 轮换密钥时只需要让CurrentKey返回新的密钥，旧的密钥要保留到所有旧文件被merge重写为止
+type KeyProvider interface {
+	// CurrentKey 新文件使用的密钥，长度必须是16、24或32字节（AES-128/192/256）
+	CurrentKey() (uint32, []byte, error)
+
+	// Key 根据文件头中的id取出打开旧文件的密钥
+	Key(id uint32) ([]byte, error)
+}
+
+// KeyRing 最简单的KeyProvider，保存所有的密钥，新文件使用CurrentID对应的密钥
+type KeyRing struct {
+	CurrentID uint32
+	Keys      map[uint32][]byte
+}
+
+func (r *KeyRing) CurrentKey() (uint32, []byte, error) {
+	key, err := r.Key(r.CurrentID)
+	return r.CurrentID, key, err
+}
+
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, ErrEncryptionKeyNotFound
+	}
+	return key, nil
+}
+
+// fileCipher 加密文件使用的AES-GCM
+type fileCipher struct {
+	keyID uint32
+	aead  cipher.AEAD
+}
+
+func newFileCipher(keyID uint32, key []byte) (*fileCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &fileCipher{keyID: keyID, aead: aead}, nil
+}
+
+// overhead 加密后的数据比明文多出的长度
+func (c *fileCipher) overhead() int {
+	return encryptionNonceSize + c.aead.Overhead()
+}
+
+// seal 把key和value加密后写入dst（nonce + 密文 + 认证标签），记录的header作为附加数据一起认证
+func (c *fileCipher) seal(dst []byte, record *LogRecord, header []byte) error {
+	nonce := dst[:encryptionNonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	plain := make([]byte, 0, len(record.Key)+len(record.Value))
+	plain = append(append(plain, record.Key...), record.Value...)
+	c.aead.Seal(dst[encryptionNonceSize:encryptionNonceSize], nonce, plain, header)
+	return nil
+}
+
+// open 解密seal写入的数据，返回key和value拼接在一起的明文
+func (c *fileCipher) open(sealed []byte, header []byte) ([]byte, error) {
+	if len(sealed) < c.overhead() {
+		return nil, ErrDecryptionFailed
+	}
+	plain, err := c.aead.Open(nil, sealed[:encryptionNonceSize], sealed[encryptionNonceSize:], header)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plain, nil
+}
+
+// NewEncryptedFile 打开一个按需加解密的文件，keys为nil时与NewFile相同
+// 以StandardFIO打开的空文件会用当前的密钥写入加密头；已有内容的文件根据文件头判断是否加密，并校验密钥
+func NewEncryptedFile(fileName string, fileId uint32, ioType fio.FileIOType, keys KeyProvider) (*File, error) {
+	return newFile(fileName, fileId, ioType, keys)
+}
+
+// KeyID 返回加密文件所用密钥的id，没有加密时返回false
+func (f *File) KeyID() (uint32, bool) {
+	if f.cipher == nil {
+		return 0, false
+	}
+	return f.cipher.keyID, true
+}
+
+// readEncryptionHeader 读取已有文件的加密头并校验密钥，没有加密头的文件是明文文件
+func (f *File) readEncryptionHeader() error {
+	size, err := f.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	if size < encryptionHeaderSize {
+		return nil
+	}
+	header := make([]byte, encryptionHeaderSize)
+	if _, err = f.IOManager.Read(header, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], encryptionMagic) {
+		return nil
+	}
+
+	if f.keys == nil {
+		return ErrEncryptionKeyRequired
+	}
+	keyID := binary.LittleEndian.Uint32(header[4:8])
+	key, err := f.keys.Key(keyID)
+	if err != nil {
+		return err
+	}
+	c, err := newFileCipher(keyID, key)
+	if err != nil {
+		return err
+	}
+	if _, err = c.aead.Open(nil, header[8:8+encryptionNonceSize], header[8+encryptionNonceSize:], header[:8]); err != nil {
+		return ErrWrongEncryptionKey
+	}
+	f.cipher = c
+	f.IOManager = &offsetIOManager{IOManager: f.IOManager, offset: encryptionHeaderSize}
+	return nil
+}
+
+// newEncryptionHeader 用当前的密钥生成新文件的加密头
+func newEncryptionHeader(keys KeyProvider) (*fileCipher, []byte, error) {
+	keyID, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := newFileCipher(keyID, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	binary.LittleEndian.PutUint32(header[4:8], keyID)
+	nonce := header[8 : 8+encryptionNonceSize]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	c.aead.Seal(header[8+encryptionNonceSize:8+encryptionNonceSize], nonce, nil, header[:8])
+	return c, header, nil
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyRing() *KeyRing {
+	return &KeyRing{
+		CurrentID: 1,
+		Keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte("k"), 32),
+			2: bytes.Repeat([]byte("n"), 16),
+		},
+	}
+}
+
+func TestNewEncryptedFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("/tmp/kv", "encrypted-file")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000000001.data")
+	keys := testKeyRing()
+
+	file, err := NewEncryptedFile(fileName, 1, fio.StandardFIO, keys)
+	assert.Nil(t, err)
+	keyID, ok := file.KeyID()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), keyID)
+
+	records := []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask-go")},
+		{Key: []byte("ttl"), Value: []byte("secret"), Expire: 123456789, Codec: CodecSnappy},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+	}
+	var sizes []int64
+	for _, record := range records {
+		encoded, size := file.EncodeLogRecord(record)
+		assert.Nil(t, file.Write(encoded))
+		sizes = append(sizes, size)
+	}
+	assert.Nil(t, file.Close())
+
+	// 磁盘上看不到明文
+	raw, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("bitcask-go")))
+	assert.False(t, bytes.Contains(raw, []byte("secret")))
+
+	// 偏移量不包含文件头，各种io方式都能读出
+	for _, ioType := range []fio.FileIOType{fio.StandardFIO, fio.MemoryMapIO, fio.ReadOnlyFIO} {
+		file, err = NewEncryptedFile(fileName, 1, ioType, keys)
+		assert.Nil(t, err)
+		var offset int64
+		for i, record := range records {
+			read, size, err := file.ReadLogRecord(offset)
+			assert.Nil(t, err)
+			assert.Equal(t, sizes[i], size)
+			assert.Equal(t, record.Key, read.Key)
+			assert.Equal(t, string(record.Value), string(read.Value))
+			assert.Equal(t, record.Type, read.Type)
+			assert.Equal(t, record.Expire, read.Expire)
+			assert.Equal(t, record.Codec, read.Codec)
+			offset += size
+		}
+		assert.Nil(t, file.Close())
+	}
+
+	// 轮换密钥后旧文件仍然用旧密钥打开，新文件使用新密钥
+	keys.CurrentID = 2
+	file, err = NewEncryptedFile(fileName, 1, fio.StandardFIO, keys)
+	assert.Nil(t, err)
+	keyID, _ = file.KeyID()
+	assert.Equal(t, uint32(1), keyID)
+	assert.Nil(t, file.Close())
+	newFile, err := NewEncryptedFile(filepath.Join(dir, "000000002.data"), 2, fio.StandardFIO, keys)
+	assert.Nil(t, err)
+	keyID, _ = newFile.KeyID()
+	assert.Equal(t, uint32(2), keyID)
+	assert.Nil(t, newFile.Close())
+
+	// 密钥错误、缺少密钥
+	keys.Keys[1] = bytes.Repeat([]byte("x"), 32)
+	_, err = NewEncryptedFile(fileName, 1, fio.StandardFIO, keys)
+	assert.Equal(t, ErrWrongEncryptionKey, err)
+	delete(keys.Keys, 1)
+	_, err = NewEncryptedFile(fileName, 1, fio.StandardFIO, keys)
+	assert.Equal(t, ErrEncryptionKeyNotFound, err)
+	_, err = NewEncryptedFile(fileName, 1, fio.StandardFIO, nil)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+}
+
+func TestNewEncryptedFile_Plaintext(t *testing.T) {
+	dir, _ := os.MkdirTemp("/tmp/kv", "encrypted-file-plaintext")
+	defer os.RemoveAll(dir)
+
+	// 启用加密之前写入的文件仍按明文读写
+	file, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	encoded, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, file.Write(encoded))
+	assert.Nil(t, file.Close())
+
+	file, err = NewEncryptedFile(GetDataFileName(dir, 1), 1, fio.StandardFIO, testKeyRing())
+	assert.Nil(t, err)
+	_, ok := file.KeyID()
+	assert.False(t, ok)
+	record, _, err := file.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), record.Value)
+	assert.Nil(t, file.Close())
+
+	// 以mmap打开的空文件不写文件头，改为标准io后再写入（mmap只能打开已经存在的文件）
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 2), nil, 0644))
+	file, err = NewEncryptedFile(GetDataFileName(dir, 2), 2, fio.MemoryMapIO, testKeyRing())
+	assert.Nil(t, err)
+	_, ok = file.KeyID()
+	assert.False(t, ok)
+	assert.Nil(t, file.SetIOManager(dir, fio.StandardFIO))
+	_, ok = file.KeyID()
+	assert.True(t, ok)
+	assert.Nil(t, file.Close())
+}
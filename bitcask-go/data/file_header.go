@@ -0,0 +1,144 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+// fileHeaderMagic 带文件头的文件以它开头，没有它的文件是版本0（没有文件头）的旧文件
+var fileHeaderMagic = []byte("GCSK")
+
+const (
+	// FileFormatVersion 当前写入的文件格式版本。版本0的文件没有文件头，直接从记录开始
+	FileFormatVersion uint16 = 1
+
+	// fileHeaderSize magic(4) + 版本(2) + 标志位(2) + 文件id(4) + 创建时间(8) + crc(4)
+	fileHeaderSize = 4 + 2 + 2 + 4 + 8 + 4
+)
+
+// FileFlagEncrypted 文件头之后紧跟着加密头，文件中的记录是加密的
+const FileFlagEncrypted uint16 = 1 << 0
+
+var (
+	ErrFileHeaderCorrupted    = errors.New("the file header is corrupted")
+	ErrUnsupportedFileVersion = errors.New("unsupported file format version, the file may be written by a newer version")
+)
+
+// FileHeader 文件头，新建文件时写入，之后不再修改
+type FileHeader struct {
+	Version   uint16 // 文件格式版本，为0表示没有文件头的旧文件
+	Flags     uint16 // 标志位
+	Fid       uint32 // 文件id（hint、merged-mark等文件为0）
+	CreatedAt int64  // 创建时间（UnixNano）
+}
+
+func encodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, fileHeaderSize)
+	copy(buf, fileHeaderMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], header.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], header.Flags)
+	binary.LittleEndian.PutUint32(buf[8:12], header.Fid)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(header.CreatedAt))
+	binary.LittleEndian.PutUint32(buf[20:], crc32.ChecksumIEEE(buf[:20]))
+	return buf
+}
+
+// decodeFileHeader 解码文件头，没有magic时返回nil（版本0的文件）
+func decodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < fileHeaderSize || !bytes.Equal(buf[:len(fileHeaderMagic)], fileHeaderMagic) {
+		return nil, nil
+	}
+	if crc32.ChecksumIEEE(buf[:20]) != binary.LittleEndian.Uint32(buf[20:fileHeaderSize]) {
+		return nil, ErrFileHeaderCorrupted
+	}
+	header := &FileHeader{
+		Version:   binary.LittleEndian.Uint16(buf[4:6]),
+		Flags:     binary.LittleEndian.Uint16(buf[6:8]),
+		Fid:       binary.LittleEndian.Uint32(buf[8:12]),
+		CreatedAt: int64(binary.LittleEndian.Uint64(buf[12:20])),
+	}
+	if header.Version == 0 || header.Version > FileFormatVersion {
+		return nil, ErrUnsupportedFileVersion
+	}
+	return header, nil
+}
+
+// initHeader 空文件写入文件头（需要加密时连同加密头一起写入）；已有内容的文件读取并校验文件头
+// 以只读方式打开的空文件不写文件头，之后改为StandardFIO时（SetIOManager）再写入
+func (f *File) initHeader(writable bool) error {
+	f.Header, f.cipher = FileHeader{}, nil
+	size, err := f.IOManager.Size()
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		if !writable {
+			return nil
+		}
+		header := FileHeader{Version: FileFormatVersion, Fid: f.Fid, CreatedAt: time.Now().UnixNano()}
+		var c *fileCipher
+		var encryptionHeader []byte
+		if f.keys != nil {
+			if c, encryptionHeader, err = newEncryptionHeader(f.keys); err != nil {
+				return err
+			}
+			header.Flags |= FileFlagEncrypted
+		}
+		if _, err = f.IOManager.Write(append(encodeFileHeader(&header), encryptionHeader...)); err != nil {
+			return err
+		}
+		f.Header, f.cipher = header, c
+		f.IOManager = &offsetIOManager{IOManager: f.IOManager, offset: int64(fileHeaderSize + len(encryptionHeader))}
+		return nil
+	}
+
+	// 版本0的文件直接从记录（或加密头）开始
+	if size >= fileHeaderSize {
+		buf := make([]byte, fileHeaderSize)
+		if _, err = f.IOManager.Read(buf, 0); err != nil {
+			return err
+		}
+		header, err := decodeFileHeader(buf)
+		if err != nil {
+			return err
+		}
+		if header != nil {
+			f.Header = *header
+			f.IOManager = &offsetIOManager{IOManager: f.IOManager, offset: fileHeaderSize}
+		}
+	}
+
+	// 版本0的文件根据加密头的magic判断是否加密
+	if f.Header.Version == 0 || f.Header.Flags&FileFlagEncrypted != 0 {
+		if err = f.readEncryptionHeader(); err != nil {
+			return err
+		}
+	}
+	if f.Header.Flags&FileFlagEncrypted != 0 && f.cipher == nil {
+		return ErrFileHeaderCorrupted
+	}
+	return nil
+}
+
+// offsetIOManager 跳过文件头（和加密头）的IOManager，上层看到的偏移量和文件大小都不包含它们
+type offsetIOManager struct {
+	fio.IOManager
+	offset int64
+}
+
+func (m *offsetIOManager) Read(b []byte, offset int64) (int, error) {
+	return m.IOManager.Read(b, offset+m.offset)
+}
+
+func (m *offsetIOManager) Size() (int64, error) {
+	size, err := m.IOManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	return size - m.offset, nil
+}
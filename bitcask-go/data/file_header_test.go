@@ -0,0 +1,90 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("/tmp/kv", "file-header")
+	defer os.RemoveAll(dir)
+
+	before := time.Now().UnixNano()
+	file, err := OpenDataFile(dir, 7, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, FileFormatVersion, file.Header.Version)
+	assert.Equal(t, uint32(7), file.Header.Fid)
+	assert.Equal(t, uint16(0), file.Header.Flags)
+	assert.True(t, file.Header.CreatedAt >= before)
+
+	// 偏移量和文件大小都不包含文件头
+	encoded, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, file.Write(encoded))
+	assert.Equal(t, size, file.WriteOffset)
+	assert.Nil(t, file.Close())
+
+	for _, ioType := range []fio.FileIOType{fio.StandardFIO, fio.MemoryMapIO, fio.ReadOnlyFIO} {
+		file, err = OpenDataFile(dir, 7, ioType)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(7), file.Header.Fid)
+		fileSize, err := file.IOManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, size, fileSize)
+		record, _, err := file.ReadLogRecord(0)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("bitcask-go"), record.Value)
+		assert.Nil(t, file.Close())
+	}
+	info, err := os.Stat(GetDataFileName(dir, 7))
+	assert.Nil(t, err)
+	assert.Equal(t, size+fileHeaderSize, info.Size())
+}
+
+func TestNewFile_Version0(t *testing.T) {
+	dir, _ := os.MkdirTemp("/tmp/kv", "file-header-v0")
+	defer os.RemoveAll(dir)
+
+	// 没有文件头的旧文件照常读取，也可以继续追加
+	encoded, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 1), encoded, 0644))
+	file, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(0), file.Header.Version)
+	record, _, err := file.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), record.Value)
+	file.WriteOffset = size
+	assert.Nil(t, file.Write(encoded))
+	_, _, err = file.ReadLogRecord(size)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+func TestNewFile_InvalidHeader(t *testing.T) {
+	dir, _ := os.MkdirTemp("/tmp/kv", "file-header-invalid")
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "000000001.data")
+
+	// 更新的版本写入的文件
+	header := encodeFileHeader(&FileHeader{Version: FileFormatVersion + 1, Fid: 1})
+	assert.Nil(t, os.WriteFile(fileName, header, 0644))
+	_, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+
+	// 文件头被破坏
+	header = encodeFileHeader(&FileHeader{Version: FileFormatVersion, Fid: 1})
+	header[10] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, header, 0644))
+	_, err = OpenDataFile(dir, 1, fio.ReadOnlyFIO)
+	assert.Equal(t, ErrFileHeaderCorrupted, err)
+
+	// 标记为加密，但是没有加密头
+	header = encodeFileHeader(&FileHeader{Version: FileFormatVersion, Fid: 1, Flags: FileFlagEncrypted})
+	assert.Nil(t, os.WriteFile(fileName, header, 0644))
+	_, err = NewEncryptedFile(fileName, 1, fio.StandardFIO, testKeyRing())
+	assert.Equal(t, ErrFileHeaderCorrupted, err)
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"time"
 )
 
 // LogRecordType LogRecord的墓碑值字段。枚举类型，正常和已删除
@@ -14,22 +15,30 @@ const (
 	TransactionFinished
 )
 
-// crc type ks vs    4+1+5+5    // 这里的ks是指keySize字段长度，而不是key字段的长度
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 1 + 4
+// logRecordExpireFlag Type字段的最高位，置位时表示header中在ValueSize之后还带有一个过期时间字段
+const logRecordExpireFlag byte = 1 << 7
+
+// crc type ks vs expire   4+1+5+5+10    // 这里的ks是指keySize字段长度，而不是key字段的长度
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 1 + 4 + binary.MaxVarintLen64
 
 // LogRecord 写入到数据文件的记录。由于是类似日志一样地追加写入的，所以叫做Log
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间（UnixNano），0表示永不过期
+	Codec  Codec // Value的压缩方式，读出的Value是压缩后的数据
 }
 
 // LogRecord 的头部信息
 type logRecordHeader struct {
 	crc        uint32        // crc 校验值
 	recordType LogRecordType // 标识 LogRecord 的类型
+	codec      Codec         // value 的压缩方式
+	encrypted  bool          // key 和 value 是否加密
 	keySize    uint32        // key 的长度
 	valueSize  uint32        // value 的长度
+	expire     int64         // 过期时间，0表示永不过期
 }
 
 // LogRecordPos 数据内存索引，用于描述数据在内存上的位置position
@@ -37,6 +46,21 @@ type LogRecordPos struct {
 	Fid    uint32 //表示数据被存放在了内存中的哪个文件里
 	Offset int64  //表示数据存放在文件的哪个位置
 	Size   uint32 //标识数据在磁盘上的大小
+	Expire int64  //过期时间（UnixNano），0表示永不过期。放在索引里，遍历时不用读磁盘就能判断是否过期
+}
+
+// IsExpired 判断记录在当前时刻是否已经过期
+func (pos *LogRecordPos) IsExpired() bool {
+	return isExpired(pos.Expire)
+}
+
+// IsExpired 判断记录在当前时刻是否已经过期
+func (lr *LogRecord) IsExpired() bool {
+	return isExpired(lr.Expire)
+}
+
+func isExpired(expire int64) bool {
+	return expire > 0 && expire <= time.Now().UnixNano()
 }
 
 // TransactionRecord 从数据文件加载数据到内存时，用于暂存事务中记录的结构体
@@ -46,23 +70,45 @@ type TransactionRecord struct {
 }
 
 // EncodeLogRecord 对数据记录LogRecord进行编码，返回编码后的字节数组和数组的长度
-// CRC		Type		KeySize		ValueSize		Keys		Value
+// CRC		Type		KeySize		ValueSize		[Expire]		Keys		Value
 //
-//	4         1           <=5          <=5           变长      变长
+//	4         1           <=5          <=5           <=10          变长      变长
+//
+// 只有设置了过期时间的记录才会写入Expire字段（同时置位Type的最高位），没有过期时间的记录编码与之前完全一致
+// value的压缩方式保存在Type的第5、6位中
 func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
+	return encodeLogRecord(record, nil)
+}
+
+// encodeLogRecord c不为nil时加密key和value，并置位Type的第4位
+// 加密后KeySize仍是key的长度，多出来的nonce和认证标签算在ValueSize中
+func encodeLogRecord(record *LogRecord, c *fileCipher) ([]byte, int64) {
 	kLen := len(record.Key)
 	vLen := len(record.Value)
+	if c != nil {
+		vLen += c.overhead()
+	}
 
 	// 初始化一个header部分的编码
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	// 第5个字节：Type
-	header[4] = record.Type
+	header[4] = record.Type | record.Codec<<logRecordCodecShift
+	if record.Expire != 0 {
+		header[4] |= logRecordExpireFlag
+	}
+	if c != nil {
+		header[4] |= logRecordEncryptedFlag
+	}
 
 	// 第6个字节开始：key/value长度
 	var index = 5
 	index += binary.PutVarint(header[index:], int64(kLen))
 	index += binary.PutVarint(header[index:], int64(vLen))
+	// 过期时间
+	if record.Expire != 0 {
+		index += binary.PutVarint(header[index:], record.Expire)
+	}
 
 	// 整条LogRecord的长度
 	var size = index + kLen + vLen
@@ -72,8 +118,13 @@ func EncodeLogRecord(record *LogRecord) ([]byte, int64) {
 	copy(encodedBytes[:index], header[:index])
 
 	// 第index个字节开始：写入key/value的实际值(直接copy过来)
-	copy(encodedBytes[index:], record.Key)
-	copy(encodedBytes[index+kLen:], record.Value)
+	if c == nil {
+		copy(encodedBytes[index:], record.Key)
+		copy(encodedBytes[index+kLen:], record.Value)
+	} else if err := c.seal(encodedBytes[index:], record, encodedBytes[4:index]); err != nil {
+		// 系统的随机数源不可用，无法安全地加密
+		panic(err)
+	}
 
 	// CRC 校验
 	crc := crc32.ChecksumIEEE(encodedBytes[4:])
@@ -91,7 +142,9 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	}
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ (logRecordExpireFlag | logRecordCodecMask | logRecordEncryptedFlag),
+		codec:      (buf[4] & logRecordCodecMask) >> logRecordCodecShift,
+		encrypted:  buf[4]&logRecordEncryptedFlag != 0,
 	}
 	var index = 5
 	keySize, n := binary.Varint(buf[index:])
@@ -100,6 +153,11 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	valueSize, n := binary.Varint(buf[index:])
 	header.valueSize = uint32(valueSize)
 	index += n
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		header.expire = expire
+		index += n
+	}
 
 	return header, int64(index)
 }
@@ -119,11 +177,12 @@ func getLogRecordCRC(r *LogRecord, header []byte) uint32 {
 
 // EncodeLogRecordPos 对位置信息结构体进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	record := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	record := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	index := 0
 	index += binary.PutVarint(record[index:], int64(pos.Fid))
 	index += binary.PutVarint(record[index:], pos.Offset)
 	index += binary.PutVarint(record[index:], int64(pos.Size))
+	index += binary.PutVarint(record[index:], pos.Expire)
 	return record[:index]
 }
 
@@ -134,10 +193,17 @@ func DecodeLogRecordPos(record []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(record[index:])
 	index += n
-	size, _ := binary.Varint(record[index:])
+	size, n := binary.Varint(record[index:])
+	index += n
+	// 旧版本的hint文件中没有过期时间字段
+	var expire int64
+	if index < len(record) {
+		expire, _ = binary.Varint(record[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(int32(fid)),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 }
@@ -96,3 +96,53 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeLogRecordWithExpire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.NotNil(t, res)
+
+	// 带过期时间的记录要比不带的长
+	_, n2 := EncodeLogRecord(&LogRecord{Key: rec.Key, Value: rec.Value})
+	assert.Greater(t, n, n2)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.Equal(t, uint32(4), h.keySize)
+	assert.Equal(t, uint32(10), h.valueSize)
+	assert.Equal(t, rec.Expire, h.expire)
+	assert.Equal(t, n, size+4+10)
+}
+
+func TestEncodeLogRecordWithCodec(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordDeleted,
+		Expire: 1700000000000000000,
+		Codec:  CodecZstd,
+	}
+	res, _ := EncodeLogRecord(rec)
+	h, _ := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, CodecZstd, h.codec)
+	assert.Equal(t, rec.Expire, h.expire)
+
+	// 没有压缩的记录编码与之前完全一致
+	res2, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Equal(t, []byte{104, 82, 240, 150, 0, 8, 20}, res2[:7])
+	h2, _ := decodeLogRecordHeader(res2)
+	assert.Equal(t, CodecNone, h2.codec)
+}
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 3, Offset: 100, Size: 20, Expire: 1700000000000000000}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+}
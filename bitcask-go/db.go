@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
+	"github.com/google/btree"
 	"io"
 	"log"
 	"os"
@@ -16,6 +17,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -39,14 +42,49 @@ type DB struct {
 	bytesWrite    uint                  // 当前距离上一次持久化累计写了多少字节
 	invalidSize   int64                 // 记录有多少数据是被update或delete的，只有这些数据是无效的，需要被merge
 	// 其中，delete时，原来的LogRecord和新加的logRecord都是不需要的
+	closed    chan struct{}   // 关闭时close该channel，通知所有后台goroutine退出
+	bgWorkers *sync.WaitGroup // 后台goroutine，Close时等待它们全部退出
+	sweeper   *expireSweeper  // 后台清理过期key的状态
+	merger    *autoMerger     // 后台自动merge的状态
+	filePins  map[uint32]int  // 被快照引用的数据文件及其引用计数，被引用的文件不能在merge时删除
+
+	fileInvalidSize map[uint32]int64 // 每个数据文件中的无效数据量，增量merge时据此挑选要重写的文件
+	hintMu          *sync.Mutex      // 生成数据文件hint时持有，merge替换数据文件时需要等待正在生成的hint写完
+	hints           *hintQueue       // 等待在后台生成hint文件的数据文件
+
+	committer *groupCommitter // 并发的同步写操作通过组提交共用一次持久化
+	deferSync bool            // 组提交过程中为true，写入时不单独持久化，由leader统一持久化
+
+	syncMu       *sync.Mutex // 保护已经持久化到的位置
+	syncedFid    uint32      // 已经持久化到的数据文件
+	syncedOffset int64       // 已经持久化到的数据文件中的偏移
+
+	rawValueSize    int64 // 本次打开以来写入的value压缩前的大小
+	storedValueSize int64 // 本次打开以来写入的value实际保存的大小
+
+	activeTxns  map[uint64]struct{} // 当前未结束的交互式事务的起始序列号
+	txnVersions map[string]uint64   // 有事务未结束时，记录每个key最后一次被修改时的序列号，用于提交时的冲突检测
+
+	expiring    *btree.BTreeG[expiringKey] // 索引中设置了过期时间的key，按过期时间排序，统计和清理过期key时不需要遍历整个索引
+	indexWriter *expiringWriter            // 正在执行的索引修改，只在持有互斥锁的写操作中使用，嵌套的updateIndex复用它
 }
 
 // Stat 数据引擎的统计信息
 type Stat struct {
-	KeyNum           uint  // Key的总数量
+	KeyNum           uint  // Key的总数量（不包含已过期的key）
 	DataFileNum      uint  // 磁盘上的数据文件数量
 	ReclaimableSize  int64 // 通过merge操作可以回收的空间大小（无效数据的数据量），以字节为单位
 	OccupiedDiscSize int64 // 数据库数据目录所占磁盘空间的大小
+
+	CompressionRatio float64 // 本次打开以来写入的value压缩前与压缩后的大小之比，为0表示还没有写入过value
+
+	ExpireSweepCycles uint64 // 后台过期key清理运行的轮数
+	ExpiredKeysSwept  uint64 // 后台清理掉的过期key数量
+
+	AutoMergeRuns     uint64        // 后台自动merge执行的次数
+	LastMergeTime     time.Time     // 上一次自动merge开始的时间
+	LastMergeDuration time.Duration // 上一次自动merge的耗时
+	LastMergeError    string        // 上一次自动merge失败的原因，为空表示成功
 }
 
 func checkOptions(options Options) error {
@@ -59,6 +97,33 @@ func checkOptions(options Options) error {
 	if options.MergeRatioThreshold < 0 || options.MergeRatioThreshold > 1 {
 		return errors.New("merge Ratio Threshold should be within [0, 1]")
 	}
+	if options.ExpireSweepInterval < 0 || options.ExpireSweepMaxKeys < 0 || options.ExpireSweepMaxDuration < 0 {
+		return errors.New("expire sweep options should not be negative")
+	}
+	if options.IndexLoadWorkers < 0 {
+		return errors.New("index load workers should not be negative")
+	}
+	if err := checkSyncPolicy(options.SyncPolicy); err != nil {
+		return err
+	}
+	if options.RecoveryMode != RecoveryStrict && options.RecoveryMode != RecoverySkipCorrupted {
+		return errors.New("unknown recovery mode")
+	}
+	if options.Compression > ZstdCompression || options.CompressionMinSize < 0 {
+		return errors.New("unknown compression or compression min size is negative")
+	}
+	if options.Encryption != nil && options.IndexType == BPTree {
+		return errors.New("the B+ tree index stores keys in plaintext, it can not be used with encryption")
+	}
+	if options.IncrementalMergeMaxFiles < 0 {
+		return errors.New("incremental merge max files should not be negative")
+	}
+	if options.AutoMergeInterval < 0 || options.AutoMergeMinReclaimable < 0 {
+		return errors.New("auto merge options should not be negative")
+	}
+	if _, err := parseMergeWindow(options.AutoMergeWindow); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -69,34 +134,85 @@ func Open(options Options) (*DB, error) {
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
-	// 检验数据目录是否存在
+	// 检验数据目录是否存在（只读模式下不创建）
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		if options.ReadOnly {
+			return nil, err
+		}
 		if err = os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
 	// 判断当前数据目录是否正在被使用（为了简洁性，只允许一个进程打开一个DB实例）
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	lockIsHold, err := fileLock.TryLock()
+	// 只读模式不加锁，不影响写进程，也可以同时有多个只读的实例
+	var fileLock *flock.Flock
+	if !options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		lockIsHold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !lockIsHold {
+			return nil, ErrDataBaseIsBeingUsed
+		}
+	}
+	// 磁盘索引文件由写进程独占，只读模式下在内存中重建索引
+	if options.ReadOnly && options.IndexType == BPTree {
+		options.IndexType = BTree
+	}
+
+	// 统一用SyncPolicy表示持久化策略
+	options.SyncPolicy = resolveSyncPolicy(options)
+
+	idx, err := index.NewIndexer(options.IndexType, options.DirPath)
 	if err != nil {
+		if fileLock != nil {
+			_ = fileLock.Unlock()
+		}
 		return nil, err
 	}
-	if !lockIsHold {
-		return nil, ErrDataBaseIsBeingUsed
-	}
 
 	// 对DB结构体进行初始化
 	db := &DB{
 		options:    options,
 		mu:         new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.File),
-		index:      index.NewIndexer(options.IndexType),
+		index:      idx,
 		flock:      fileLock,
+		closed:     make(chan struct{}),
+		bgWorkers:  new(sync.WaitGroup),
+		sweeper:    new(expireSweeper),
+		merger:     new(autoMerger),
+		filePins:   make(map[uint32]int),
+
+		fileInvalidSize: make(map[uint32]int64),
+		hintMu:          new(sync.Mutex),
+		hints:           newHintQueue(),
+		committer:       new(groupCommitter),
+		syncMu:          new(sync.Mutex),
+
+		activeTxns:  make(map[uint64]struct{}),
+		txnVersions: make(map[string]uint64),
+		expiring:    newExpiringKeys(),
+	}
+	// 打开失败时释放已经占用的资源，之后可以再次打开（例如换用正确的密钥）
+	opened := false
+	defer func() {
+		if !opened {
+			db.releaseOnOpenFailure()
+		}
+	}()
+
+	// 不使用磁盘索引时，之前留下的磁盘索引文件已经不会再被更新，删掉以免之后误用
+	if _, ok := db.index.(index.Persistent); !ok && !options.ReadOnly {
+		if err := os.Remove(filepath.Join(options.DirPath, index.BPTreeIndexFileName)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
 	}
 
 	// S2 加载merge数据目录
-	err = db.loadMergeFiles()
+	merged, err := db.loadMergeFiles()
 	if err != nil {
 		return nil, err
 	}
@@ -106,36 +222,106 @@ func Open(options Options) (*DB, error) {
 	ioType := fio.StandardFIO
 	if db.options.MMapAtStartupNeeded {
 		ioType = fio.MemoryMapIO
+	} else if db.options.ReadOnly {
+		ioType = fio.ReadOnlyFIO
 	}
 	if err2 := db.loadDataFiles(ioType); err2 != nil {
 		return nil, err2
 	}
+	// 只读模式下没有加锁，加载数据文件期间写进程可能开始移动merge后的文件，加载完再检查一次
+	if db.options.ReadOnly {
+		if err2 := db.checkMergeNotMoving(); err2 != nil {
+			return nil, err2
+		}
+	}
 
 	// S4 构建内存索引
-	// 如果存在hint文件，直接从hint文件中加载merge后的记录的索引
-	// 从 hint 索引文件中加载索引
-	if err2 := db.loadIndexFromHint(); err2 != nil {
+	if err2 := db.loadIndex(merged); err2 != nil {
 		return nil, err2
 	}
-	if err2 := db.loadIndexFromDataFiles(); err2 != nil {
-		return nil, err2
+	db.loadExpiringKeys()
+	// 磁盘索引加载完后保存检查点，之后每次写入都在同一个bolt事务中更新它
+	if persistent, ok := db.index.(index.Persistent); ok {
+		if err = persistent.SaveCheckpoint(db.checkpoint()); err != nil {
+			return nil, err
+		}
 	}
 
-	// 启动完需要把每个文件的ioManager重置回标准IO
-	if ioType == fio.MemoryMapIO {
+	// 启动完需要把每个文件的ioManager重置回标准IO（只读模式下继续使用内存映射读取）
+	if ioType == fio.MemoryMapIO && !db.options.ReadOnly {
 		err = db.resetIOTypeToStandardIO()
 		if err != nil {
 			return nil, err
 		}
 	}
+
+	// 之前写入的数据不一定已经持久化，先持久化一次，从这里开始记录已经持久化到的位置
+	if db.activeFile != nil && !db.options.ReadOnly {
+		if err = db.syncActiveFileWithoutLock(); err != nil {
+			return nil, err
+		}
+	}
+
+	// S5 启动后台任务
+	db.startBackgroundWorkers()
+	opened = true
 	return db, nil
 }
 
+// releaseOnOpenFailure 释放文件锁，关闭索引和已经打开的数据文件
+func (db *DB) releaseOnOpenFailure() {
+	if db.flock != nil {
+		_ = db.flock.Unlock()
+	}
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+}
+
+// startBackgroundWorkers 根据配置启动后台goroutine，它们都在Close时退出
+// 后台任务都会修改数据目录，只读模式下不启动
+func (db *DB) startBackgroundWorkers() {
+	if db.options.ReadOnly {
+		return
+	}
+	if db.options.ExpireSweepInterval > 0 {
+		db.bgWorkers.Add(1)
+		go db.runExpireSweeper()
+	}
+	if db.options.DataFileHint {
+		db.bgWorkers.Add(1)
+		go db.runHintWriter()
+		db.writeDataFileHintsInBackground(db.filesWithoutHint(), true)
+	}
+	if db.options.AutoMergeInterval > 0 {
+		db.bgWorkers.Add(1)
+		go db.runAutoMerger()
+	}
+	if db.options.SyncPolicy.Mode == SyncInterval {
+		db.bgWorkers.Add(1)
+		go db.runIntervalSyncer()
+	}
+}
+
 func (db *DB) Close() error {
+	// 先通知后台goroutine退出并等待，它们可能还在使用数据文件（重复Close时不再close channel）
+	select {
+	case <-db.closed:
+	default:
+		close(db.closed)
+	}
+	db.bgWorkers.Wait()
+
 	defer func() {
-		// 释放文件锁
-		if err := db.flock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
+		// 释放文件锁（只读模式没有加锁）
+		if db.flock != nil {
+			if err := db.flock.Unlock(); err != nil {
+				panic(fmt.Sprintf("failed to unlock the directory, %v", err))
+			}
 		}
 		// 关闭索引
 		if err := db.index.Close(); err != nil {
@@ -148,6 +334,18 @@ func (db *DB) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 磁盘索引保存检查点，下次打开时不需要重建
+	if persistent, ok := db.index.(index.Persistent); ok {
+		if err := persistent.SaveCheckpoint(db.checkpoint()); err != nil {
+			return err
+		}
+	} else if db.options.IndexSnapshot && !db.options.ReadOnly {
+		// 内存索引写入索引快照，下次打开时不需要重建
+		if err := db.writeIndexSnapshot(); err != nil {
+			return err
+		}
+	}
+
 	//	关闭当前活跃文件
 	if err := db.activeFile.Close(); err != nil {
 		return err
@@ -163,12 +361,13 @@ func (db *DB) Close() error {
 
 // Sync 持久化当前活跃文件（旧文件在变成旧文件的那一刻就自动sync了，无需手动sync）
 func (db *DB) Sync() error {
-	if db.activeFile == nil {
+	// 只读模式下没有需要持久化的数据
+	if db.activeFile == nil || db.options.ReadOnly {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.SyncFile()
+	return db.syncActiveFileWithoutLock()
 }
 
 // Stat 统计数据库的各项信息
@@ -186,11 +385,24 @@ func (db *DB) Stat() *Stat {
 		panic(fmt.Sprintf("failed to get the size of data file directory: %v", err))
 	}
 
+	db.merger.mu.Lock()
+	defer db.merger.mu.Unlock()
+
 	return &Stat{
-		KeyNum:           uint(db.index.Size()),
+		KeyNum:           db.keyNum(),
 		DataFileNum:      dataFileNum,
 		ReclaimableSize:  db.invalidSize,
 		OccupiedDiscSize: dirSize,
+
+		CompressionRatio: db.compressionRatio(),
+
+		ExpireSweepCycles: atomic.LoadUint64(&db.sweeper.cycles),
+		ExpiredKeysSwept:  atomic.LoadUint64(&db.sweeper.swept),
+
+		AutoMergeRuns:     db.merger.runs,
+		LastMergeTime:     db.merger.lastTime,
+		LastMergeDuration: db.merger.lastDuration,
+		LastMergeError:    db.merger.lastErr,
 	}
 }
 
@@ -198,6 +410,9 @@ func (db *DB) Stat() *Stat {
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	// 等正在生成的hint写完，避免拷贝到写了一半、随后被删除的临时文件
+	db.hintMu.Lock()
+	defer db.hintMu.Unlock()
 
 	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
@@ -212,7 +427,7 @@ func (db *DB) SetActiveFile() error {
 		ID = db.activeFile.Fid + 1
 	}
 	// 打开当前活跃文件
-	currentActiveFile, err := data.OpenDataFile(db.options.DirPath, ID, fio.StandardFIO)
+	currentActiveFile, err := db.openDataFile(db.options.DirPath, ID, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
@@ -230,19 +445,39 @@ func (db *DB) Put(key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	return db.commitWrite(db.syncOnWrite(), func() error {
+		return db.putWithoutLock(key, value, 0)
+	})
+}
+
+// putWithoutLock 写入一条带过期时间的记录（expire为0表示永不过期），并更新内存索引
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) putWithoutLock(key []byte, value []byte, expire int64) error {
 	// S1
 	// 构造LogRecord结构体，暂存要存入数据文件的键值对
-	logRecord := &data.LogRecord{Key: encodeKeyWithSeqNo(key, NonTransaction), Value: value, Type: data.LogRecordNormal}
+	logRecord := &data.LogRecord{
+		Key:    encodeKeyWithSeqNo(key, NonTransaction),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
 
 	// 追加写入当前活跃文件，并且拿到数据位置的索引信息
-	position, err := db.appendLogRecordWithLock(logRecord)
+	position, err := db.appendLogRecordWithoutLock(logRecord)
 	if err != nil {
 		return err
 	}
 	// S2
-	if oldPos := db.index.Put(key, position); oldPos != nil {
-		db.invalidSize += int64(oldPos.Size)
+	// 在锁内更新索引，避免与merge中清理过期key的操作交错
+	if err = db.updateIndex(func(idx index.Writer) error {
+		if oldPos := idx.Put(key, position); oldPos != nil {
+			db.addInvalidSize(oldPos)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
+	db.markModifiedWithoutLock(key, db.seqNo+1)
 	return nil
 }
 
@@ -254,9 +489,16 @@ func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	return db.commitWrite(db.syncOnWrite(), func() error {
+		return db.deleteWithoutLock(key)
+	})
+}
 
+// deleteWithoutLock 写入删除记录，并从内存索引中删除key
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) deleteWithoutLock(key []byte) error {
 	// S1
-	if pos := db.index.Get(key); pos == nil {
+	if pos := db.indexGet(key); pos == nil {
 		log.Print("the key is not in the database")
 		return nil
 	}
@@ -267,21 +509,88 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDeleted,
 	}
 
-	pos, err := db.appendLogRecordWithLock(logRecord) // 在这里才写入 db.appendLogRecordWithLock(logRecord)中加了写锁
+	pos, err := db.appendLogRecordWithoutLock(logRecord)
 	if err != nil {
 		return err
 	}
-	db.invalidSize += int64(pos.Size)
+	db.addInvalidSize(pos)
 
 	// S3
-	oldPos, ok := db.index.Delete(key)
+	if err = db.updateIndex(func(idx index.Writer) error {
+		oldPos, ok := idx.Delete(key)
+		if !ok {
+			return ErrIndexUpdateFailed
+		}
+		if oldPos != nil {
+			db.addInvalidSize(oldPos)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	db.markModifiedWithoutLock(key, db.seqNo+1)
+	return nil
+}
+
+// updateIndex 修改索引。磁盘索引在一个bolt事务中完成fn中所有的修改，并在同一个事务中保存新的检查点，
+// 一次写入（包括一个WriteBatch）的索引修改要么全部生效，要么全部不生效；进程崩溃后重新打开时只需要重放检查点之后的记录
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) updateIndex(fn func(idx index.Writer) error) error {
+	// 嵌套调用时（组提交中的每个写操作）直接使用外层的writer，修改随外层一起生效
+	if db.indexWriter != nil {
+		return fn(db.indexWriter)
+	}
+	w := &expiringWriter{}
+	db.indexWriter = w
+	defer func() { db.indexWriter = nil }()
+
+	persistent, ok := db.index.(index.Persistent)
 	if !ok {
-		return ErrIndexUpdateFailed
+		w.Writer = db.index
+		err := fn(w)
+		// 内存索引没有回滚，失败时已经做了的修改同样需要记录
+		w.apply(db.expiring)
+		return err
 	}
-	if oldPos != nil {
-		db.invalidSize += int64(oldPos.Size)
+	err := persistent.Update(func(idx index.Writer) error {
+		w.Writer = idx
+		return fn(w)
+	}, db.checkpoint)
+	if err == nil {
+		w.apply(db.expiring)
 	}
-	return nil
+	return err
+}
+
+// indexGet 读取key在索引中的位置，在updateIndex的fn中调用时能读到还没有生效的修改（例如组提交中前面的写操作）
+// *************** 访问此方法前必须持有锁 ******************
+func (db *DB) indexGet(key []byte) *data.LogRecordPos {
+	if db.indexWriter != nil {
+		return db.indexWriter.Get(key)
+	}
+	return db.index.Get(key)
+}
+
+// checkpoint 当前索引对应的检查点：活跃文件写到的位置之前的记录都已经在索引中
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) checkpoint() *index.Checkpoint {
+	cp := &index.Checkpoint{
+		SeqNo:       db.seqNo,
+		InvalidSize: db.invalidSize,
+
+		FileInvalidSize: db.fileInvalidSize,
+	}
+	if db.activeFile != nil {
+		cp.Fid, cp.Offset = db.activeFile.Fid, db.activeFile.WriteOffset
+	}
+	return cp
+}
+
+// addInvalidSize 把pos处的记录计入无效数据（总量以及它所在的数据文件）
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) addInvalidSize(pos *data.LogRecordPos) {
+	db.invalidSize += int64(pos.Size)
+	db.fileInvalidSize[pos.Fid] += int64(pos.Size)
 }
 
 // Get 根据key读数据。
@@ -309,7 +618,7 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 	// S1
 	pos := db.index.Get(key)
-	if pos == nil {
+	if pos == nil || pos.IsExpired() {
 		return nil, ErrKeyNotFound
 	}
 	// S2,3
@@ -337,25 +646,57 @@ func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 		return nil, err
 	}
 	// 因为是利用墓碑值来删除，所以可能找出了key对应的数据记录但是实际上它是已经被删除了的。
-	if logRecord.Type == data.LogRecordDeleted {
+	// 已经过期的记录同样视为不存在
+	if logRecord.Type == data.LogRecordDeleted || logRecord.IsExpired() {
 		return nil, ErrKeyNotFound
 	}
-	return logRecord.Value, nil
+	return data.DecompressValue(logRecord.Codec, logRecord.Value)
 }
 
-// ListKeys 获取数据库中所有key的list
+// ListKeys 获取数据库中所有key的list（不包含已过期的key）
 func (db *DB) ListKeys() [][]byte {
 	iter := db.index.Iterator(false)
 	defer iter.Close()
-	keys := make([][]byte, db.index.Size())
-	var i int
+	keys := make([][]byte, 0, db.index.Size())
 	for iter.Rewind(); iter.IsValid(); iter.Next() {
-		keys[i] = iter.Key()
-		i += 1
+		if iter.Value().IsExpired() {
+			continue
+		}
+		keys = append(keys, iter.Key())
 	}
 	return keys
 }
 
+// Fold 遍历所有未过期的数据，并对每一条数据执行fn。fn返回false时终止遍历
+func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.IsValid(); iter.Next() {
+		pos := iter.Value()
+		if pos.IsExpired() {
+			continue
+		}
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		if !fn(iter.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// keyNum 统计未过期的key的数量：索引中key的数量减去已过期的key的数量
+func (db *DB) keyNum() uint {
+	var expired int
+	db.ascendExpired(func(string) { expired++ })
+	return uint(db.index.Size() - expired)
+}
+
 // appendLogRecordWithLock 向数据文件追加写入LogRecord，返回数据记录的索引信息，或者可能存在的error
 // S1:判断当前是否有活跃数据文件,没有就set一个
 // S2:判断写入后会不会超过文件大小阈值。超过就重新set活跃数据文件
@@ -364,6 +705,9 @@ func (db *DB) ListKeys() [][]byte {
 // S2: Check if writing will exceed the file size threshold. If it does, reset the active data file.
 // S3: Determine if persistence is required.
 func (db *DB) appendLogRecordWithoutLock(record *data.LogRecord) (*data.LogRecordPos, error) {
+	if db.options.ReadOnly {
+		return nil, ErrDataBaseIsReadOnly
+	}
 	// 判断当前是否存在活跃文件
 	// 若当前没有活跃文件，先进行初始化
 	if db.activeFile == nil {
@@ -373,22 +717,39 @@ func (db *DB) appendLogRecordWithoutLock(record *data.LogRecord) (*data.LogRecor
 		}
 	}
 
+	// 按配置压缩value，并统计压缩前后的大小
+	if record.Type == data.LogRecordNormal {
+		rawSize := len(record.Value)
+		if err := db.compressRecord(record); err != nil {
+			return nil, err
+		}
+		db.rawValueSize += int64(rawSize)
+		db.storedValueSize += int64(len(record.Value))
+	}
+
 	// 对记录进行编码，并追加写入
-	encoded, size := data.EncodeLogRecord(record)
+	encoded, size := db.activeFile.EncodeLogRecord(record)
 	// 写入前需要进行一个判断：如果当前活跃文件写入当前数据后的大小超过的阈值，需要进行更新操作。
 	// 将当前活跃文件转变为old文件，并打开一个新的文件作为活跃文件
 	if db.activeFile.WriteOffset+size > db.options.DataFileSize {
 		// 先对当前活跃文件持久化
-		if err := db.activeFile.SyncFile(); err != nil {
+		if err := db.syncActiveFileWithoutLock(); err != nil {
 			return nil, err
 		}
 		// 持久化后，转换为旧文件
-		db.olderFiles[db.activeFile.Fid] = db.activeFile
+		sealedFile := db.activeFile
+		db.olderFiles[sealedFile.Fid] = sealedFile
 		// 更新新的活跃文件
 		err := db.SetActiveFile()
 		if err != nil {
 			return nil, err
 		}
+		// 新的活跃文件可能换了密钥，按新文件重新编码
+		encoded, size = db.activeFile.EncodeLogRecord(record)
+		// 旧文件不会再被写入，在后台为它生成hint文件
+		if db.options.DataFileHint {
+			db.writeDataFileHintsInBackground([]*data.File{sealedFile}, false)
+		}
 	}
 
 	// 将数据写入当前活跃文件
@@ -402,25 +763,25 @@ func (db *DB) appendLogRecordWithoutLock(record *data.LogRecord) (*data.LogRecor
 	db.bytesWrite += uint(size)
 
 	// 持久化策略
-	var needSync = db.options.SyncWrites
-	// 1.用户没有设置每次写入都立即进行持久化，则没写n个字节自动进行一次持久化（默认为0，即不进行持久化）
-	// 2.用户胡设置了每次写入后立即持久化
-	if !needSync && db.options.SyncPerBytes > 0 && db.bytesWrite > db.options.SyncPerBytes {
+	var needSync bool
+	switch db.options.SyncPolicy.Mode {
+	case SyncAlways:
+		// 1.每次写入后立即进行持久化
 		needSync = true
+	case SyncEveryNBytes:
+		// 2.每写n个字节自动进行一次持久化
+		needSync = db.bytesWrite >= db.options.SyncPolicy.Bytes
 	}
-	if needSync {
-		err = db.activeFile.SyncFile()
-		if err != nil {
+	// 3.组提交时由leader统一持久化
+	if needSync && !db.deferSync {
+		// 持久化后清空累计值
+		if err = db.syncActiveFileWithoutLock(); err != nil {
 			return nil, err
 		}
-		// 清空累计值
-		if db.bytesWrite > 0 {
-			db.bytesWrite = 0
-		}
 	}
 
 	// 构造内存的索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.Fid, Offset: OffsetStart, Size: uint32(size)}
+	pos := &data.LogRecordPos{Fid: db.activeFile.Fid, Offset: OffsetStart, Size: uint32(size), Expire: record.Expire}
 	return pos, nil
 }
 
@@ -447,6 +808,16 @@ func (db *DB) loadDataFiles(ioType fio.FileIOType) error {
 	// 遍历该目录下的所有文件，找到所有 *.data 文件
 	var fids []int // uint32 ??
 	for _, file := range dir {
+		// 上次没有完成的增量merge留下的临时文件（只读模式下直接忽略）
+		if strings.HasSuffix(file.Name(), compactFileSuffix) {
+			if db.options.ReadOnly {
+				continue
+			}
+			if err = os.Remove(filepath.Join(db.options.DirPath, file.Name())); err != nil {
+				return err
+			}
+			continue
+		}
 		if strings.HasSuffix(file.Name(), data.FileSuffix) {
 			fid, err1 := strconv.Atoi(strings.Split(file.Name(), ".")[0])
 			if err1 != nil {
@@ -462,10 +833,15 @@ func (db *DB) loadDataFiles(ioType fio.FileIOType) error {
 	// S2
 	// 遍历每个文件，打开
 	for i, id := range fids {
-		dataFile, err1 := data.OpenDataFile(db.options.DirPath, uint32(id), ioType)
+		dataFile, err1 := db.openDataFile(db.options.DirPath, uint32(id), ioType)
 		if err1 != nil {
 			return err1
 		}
+		// 文件头中的id与文件名不一致，文件被改名或者来自别的数据目录（版本0的文件没有文件头，不检查）
+		if dataFile.Header.Version > 0 && dataFile.Header.Fid != uint32(id) {
+			_ = dataFile.Close()
+			return ErrDataFileDirectoryCorrupted
+		}
 
 		// 最后一个数据文件就是活跃文件,其他的都是旧文件
 		if i == len(fids)-1 {
@@ -479,10 +855,57 @@ func (db *DB) loadDataFiles(ioType fio.FileIOType) error {
 	return nil
 }
 
+// loadIndex 构建索引
+// 磁盘索引上次正常关闭、且之后没有发生过merge时，只需要加载检查点之后的记录；否则清空后重建
+// 内存索引在有可用的索引快照时同理
+// merged 表示这次打开时刚刚用merge后的文件替换了旧的数据文件
+func (db *DB) loadIndex(merged bool) error {
+	if persistent, ok := db.index.(index.Persistent); ok {
+		cp, err := persistent.LoadCheckpoint()
+		if err != nil {
+			return err
+		}
+		if cp != nil && !merged && db.isValidCheckpoint(cp) {
+			db.invalidSize, db.fileInvalidSize = cp.InvalidSize, cp.FileInvalidSize
+			return db.loadIndexFromDataFiles(cp)
+		}
+		if err = persistent.Clear(); err != nil {
+			return err
+		}
+	} else if cp := db.loadIndexSnapshot(merged); cp != nil {
+		// 内存索引直接从上次关闭时的索引快照中恢复
+		db.invalidSize, db.fileInvalidSize = cp.InvalidSize, cp.FileInvalidSize
+		return db.loadIndexFromDataFiles(cp)
+	}
+
+	// 如果存在hint文件，直接从hint文件中加载merge后的记录的索引
+	// 从 hint 索引文件中加载索引
+	if err := db.loadIndexFromHint(); err != nil {
+		return err
+	}
+	return db.loadIndexFromDataFiles(nil)
+}
+
+// isValidCheckpoint 检查点指向的数据文件必须存在，且位置不能超过文件大小
+func (db *DB) isValidCheckpoint(cp *index.Checkpoint) bool {
+	var dataFile *data.File
+	if db.activeFile != nil && cp.Fid == db.activeFile.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[cp.Fid]
+	}
+	if dataFile == nil {
+		return false
+	}
+	size, err := dataFile.IOManager.Size()
+	return err == nil && cp.Offset <= size
+}
+
 // loadIndexFromDataFiles 根据数据文件中构建内存索引
 // 遍历各个文件中的所有记录，构建内存索引。（后续可以实现hint文件）
+// cp 不为nil时，只加载检查点之后的记录
 // Iterate through all records in each file and build an in-memory index.
-func (db *DB) loadIndexFromDataFiles() error {
+func (db *DB) loadIndexFromDataFiles(cp *index.Checkpoint) error {
 	// 如果没有文件，说明是空的，直接返回
 	if len(db.loadedFileIds) == 0 {
 		return nil
@@ -508,28 +931,87 @@ func (db *DB) loadIndexFromDataFiles() error {
 
 	// 定义了一个方法用于更新内存索引
 	updateIndex := func(key []byte, t data.LogRecordType, pos *data.LogRecordPos) {
-		// 是否已被删除
+		// 是否已被删除（已过期的记录和删除记录一样，不再放进索引）
 		var oldPos *data.LogRecordPos
-		if t == data.LogRecordDeleted {
+		if t == data.LogRecordDeleted || pos.IsExpired() {
 			oldPos, _ = db.index.Delete(key)
-			db.invalidSize += int64(pos.Size)
+			db.addInvalidSize(pos)
 		} else {
 			oldPos = db.index.Put(key, pos)
 		}
 		if oldPos != nil {
-			db.invalidSize += int64(oldPos.Size)
+			db.addInvalidSize(oldPos)
 		}
 	}
 	// 如果使用了事务，用于暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	currentSeqNo := NonTransaction
+	if cp != nil {
+		currentSeqNo = cp.SeqNo
+	}
+
+	// 定义了一个方法用于加载一条记录（来自数据文件或者数据文件的hint文件）
+	loadRecord := func(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+		// 解码出实际的key（数据文件中的key是key+事务序列号）
+		realKey, seqNo := decodeKeyWithSeqNo(logRecord.Key)
+
+		// 非事务
+		if seqNo == NonTransaction {
+			updateIndex(realKey, logRecord.Type, pos)
+		} else {
+			// 通过write batch提交的事务
+			if logRecord.Type == data.TransactionFinished {
+				// 如果是事务提交完成的标识，则将带有该事务序列号的数据一起更新进内存索引
+				for _, tRecord := range transactionRecords[seqNo] {
+					updateIndex(tRecord.Record.Key, tRecord.Record.Type, tRecord.Position)
+				}
+				delete(transactionRecords, seqNo)
+				// 事务完成的标识本身也是merge时可以回收的数据
+				db.addInvalidSize(pos)
+			} else {
+				// 还没有提交成功，先暂存起来
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record:   logRecord,
+					Position: pos,
+				})
+			}
+		}
+
+		// 记录最大的事务序列号
+		if currentSeqNo < seqNo {
+			currentSeqNo = seqNo
+		}
+	}
+
+	// 不需要加载的文件
+	skipFile := func(fileId uint32) bool {
+		// 如果file id 小于 firstNonMergedFid，说明已经通过hint文件加载进内存index，直接跳过
+		// 检查点之前的记录已经在磁盘索引中了
+		return (isMerged && fileId < firstNonMergedFid) || (cp != nil && fileId < cp.Fid)
+	}
+	// 需要从头完整加载的旧文件（活跃文件、检查点所在的文件需要从某个位置开始扫描）
+	isWholeOlderFile := func(fileId uint32) bool {
+		return fileId != db.activeFile.Fid && (cp == nil || fileId != cp.Fid)
+	}
+
+	// 开启了并发加载时，先并发解码所有需要完整加载的旧文件，下面再按文件id顺序取出结果
+	var decoder *dataFileDecoder
+	if db.options.IndexLoadWorkers > 1 {
+		var files []*data.File
+		for _, fid := range db.loadedFileIds {
+			if fileId := uint32(fid); !skipFile(fileId) && isWholeOlderFile(fileId) {
+				files = append(files, db.olderFiles[fileId])
+			}
+		}
+		decoder = db.newDataFileDecoder(files, db.options.IndexLoadWorkers)
+		defer decoder.Close()
+	}
 
 	// 加载每一个文件，前n-1个文件是旧文件，最后一个文件是活跃文件，需要维护它的WriteOffSet
 	for _, fid := range db.loadedFileIds {
 		var fileId = uint32(fid)
-
-		// 如果file id 小于 firstNonMergedFid，说明已经通过hint文件加载进内存index，直接跳过
-		if isMerged && fileId < firstNonMergedFid {
+		if skipFile(fileId) {
 			continue
 		}
 
@@ -543,50 +1025,58 @@ func (db *DB) loadIndexFromDataFiles() error {
 			dataFile = db.olderFiles[fileId]
 		}
 		var offset int64 = 0
+		if cp != nil && fileId == cp.Fid {
+			offset = cp.Offset
+			if isActive {
+				db.activeFile.WriteOffset = offset
+			}
+		}
+
+		if isWholeOlderFile(fileId) {
+			// 已经并发解码好了
+			if decoder != nil {
+				records, err := decoder.Next()
+				if err != nil {
+					return err
+				}
+				for _, record := range records {
+					loadRecord(record.Record, record.Position)
+				}
+				continue
+			}
+			// 旧文件优先从它的hint文件加载，不需要扫描整个数据文件
+			if hintRecords, ok := db.readDataFileHint(dataFile); ok {
+				for _, hintRecord := range hintRecords {
+					loadRecord(hintRecord.Record, hintRecord.Position)
+				}
+				continue
+			}
+		}
 
 		// 循环读取文件中的记录，读到EOF时跳出循环
 		for {
 			// 注意：这里的err不能直接返回，因为如果读到文件末尾，也会返回EOF。
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
-				if err == io.EOF {
+				if err == io.EOF || db.skipCorruption(fileId, offset, err) {
 					break
-				} else {
-					return err
 				}
-			}
-
-			// 构造内存索引
-			pos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
-
-			// 解码出实际的key（数据文件中的key是key+事务序列号）
-			realKey, seqNo := decodeKeyWithSeqNo(logRecord.Key)
-
-			// 非事务
-			if seqNo == NonTransaction {
-				updateIndex(realKey, logRecord.Type, pos)
-			} else {
-				// 通过write batch提交的事务
-				if logRecord.Type == data.TransactionFinished {
-					// 如果是事务提交完成的标识，则将带有该事务序列号的数据一起更新进内存索引
-					for _, tRecord := range transactionRecords[seqNo] {
-						updateIndex(tRecord.Record.Key, tRecord.Record.Type, tRecord.Position)
+				// 活跃文件末尾没写完的记录，下面截断；活跃文件中间的损坏与旧文件一样按RecoveryMode处理
+				if isActive {
+					torn, err1 := isTornTail(dataFile, offset, size, err)
+					if err1 != nil {
+						return err1
+					}
+					if torn {
+						break
 					}
-					delete(transactionRecords, seqNo)
-				} else {
-					// 还没有提交成功，先暂存起来
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record:   logRecord,
-						Position: pos,
-					})
 				}
+				return err
 			}
 
-			// 记录最大的事务序列号
-			if currentSeqNo < seqNo {
-				currentSeqNo = seqNo
-			}
+			// 构造内存索引
+			pos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
+			loadRecord(logRecord, pos)
 
 			offset += size
 
@@ -595,6 +1085,12 @@ func (db *DB) loadIndexFromDataFiles() error {
 				db.activeFile.WriteOffset = offset
 			}
 		}
+
+		if isActive {
+			if err := db.truncateTornTail(offset); err != nil {
+				return err
+			}
+		}
 	}
 
 	// 将当前事务序列号记录进db
@@ -1,10 +1,12 @@
 package bitcask_go
 
 import (
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -421,3 +423,74 @@ func TestDB_OpenWithMMap(t *testing.T) {
 	assert.Nil(t, err2)
 	assert.NotNil(t, db2)
 }
+
+func TestOpen_BPTreeIndex(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-bptree"
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	reclaimable := db.Stat().ReclaimableSize
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.正常关闭后重新打开，直接使用磁盘索引
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(90), db.Stat().KeyNum)
+	assert.Equal(t, reclaimable, db.Stat().ReclaimableSize)
+	_, err = db.Get(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(50), val)
+
+	// 2.没有正常关闭时，索引和每次写入时一起保存的检查点依然一致，重新打开时只重放检查点之后的记录
+	err = db.Put(utils.GetTestKey(5), utils.GetTestKey(55))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.PendingDelete(utils.GetTestKey(50)))
+	assert.Nil(t, wb.Commit())
+	fid, offset := db.activeFile.Fid, db.activeFile.WriteOffset
+	_ = db.activeFile.Close()
+	_ = db.index.Close()
+	_ = db.flock.Unlock()
+
+	tree, err := index.NewBPlusTree(opts.DirPath)
+	assert.Nil(t, err)
+	cp, err := tree.LoadCheckpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, fid, cp.Fid)
+	assert.Equal(t, offset, cp.Offset)
+	assert.Nil(t, tree.SaveCheckpoint(cp))
+	assert.Nil(t, tree.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(90), db.Stat().KeyNum)
+	val, err = db.Get(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(55), val)
+	_, err = db.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 3.换成内存索引打开时，磁盘索引文件被删除
+	opts.IndexType = BTree
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(opts.DirPath, index.BPTreeIndexFileName))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, uint(90), db.Stat().KeyNum)
+}
@@ -0,0 +1,53 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+)
+
+// DeleteRange 原子地删除 [start, end) 范围内的所有key。end为nil时删除start之后的所有key
+// 所有的删除记录使用同一个事务序列号写入，最后写入一条事务完成的标识，
+// 中途崩溃时重启加载索引会丢弃这些没有完成标识的记录，不会出现只删除了一部分的情况
+func (db *DB) DeleteRange(start []byte, end []byte) error {
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	return db.deleteMatching(start, func(key []byte) bool {
+		return end == nil || bytes.Compare(key, end) < 0
+	})
+}
+
+// DeletePrefix 原子地删除所有以prefix开头的key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.deleteMatching(prefix, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	})
+}
+
+// deleteMatching 从start开始按顺序遍历索引，删除所有满足inRange的key，遇到第一个不满足的key时停止
+func (db *DB) deleteMatching(start []byte, inRange func(key []byte) bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var records []*data.LogRecord
+	iter := db.index.Iterator(false)
+	for iter.Seek(start); iter.IsValid(); iter.Next() {
+		key := iter.Key()
+		if !inRange(key) {
+			break
+		}
+		records = append(records, &data.LogRecord{
+			Key:  key,
+			Type: data.LogRecordDeleted,
+		})
+	}
+	iter.Close()
+
+	if len(records) == 0 {
+		return nil
+	}
+	return db.writeTransactionWithoutLock(records, db.syncOnWrite())
+}
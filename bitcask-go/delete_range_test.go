@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-delete-range"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	invalidSize := db.invalidSize
+
+	// GetTestKey 的格式为 "bitcask-go-key-%09d"
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	// 被删除的记录、删除记录和事务完成标识都计入无效数据
+	assert.Greater(t, db.invalidSize, invalidSize)
+
+	// 空范围
+	err = db.DeleteRange(utils.GetTestKey(50), utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, len(db.ListKeys()))
+
+	// 重启之后依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	assert.Equal(t, 90, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(15))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-delete-range"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "user:1", "user:2", "user:3", "users", "z"} {
+		err := db.Put([]byte(key), []byte(key))
+		assert.Nil(t, err)
+	}
+
+	err = db.DeletePrefix([]byte("user:"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("users"), []byte("z")}, db.ListKeys())
+
+	err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_DeleteRangeCrashRecovery(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-delete-range"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	// 模拟范围删除写到一半时崩溃：写入了带事务序列号的删除记录，但没有事务完成标识
+	for i := 0; i < 5; i++ {
+		_, err := db.appendLogRecordWithLock(&data.LogRecord{
+			Key:  encodeKeyWithSeqNo(utils.GetTestKey(i), db.seqNo+1),
+			Type: data.LogRecordDeleted,
+		})
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启后这些删除不生效
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	assert.Equal(t, 10, len(db2.ListKeys()))
+}
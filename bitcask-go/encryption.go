@@ -0,0 +1,16 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// openFile 打开数据目录中的文件，配置了加密时新文件用当前的密钥加密，已有的文件按文件头中的密钥解密
+func (db *DB) openFile(fileName string, fid uint32, ioType fio.FileIOType) (*data.File, error) {
+	return data.NewEncryptedFile(fileName, fid, ioType, db.options.Encryption)
+}
+
+// openDataFile 打开dirPath目录下fid号数据文件
+func (db *DB) openDataFile(dirPath string, fid uint32, ioType fio.FileIOType) (*data.File, error) {
+	return db.openFile(data.GetDataFileName(dirPath, fid), fid, ioType)
+}
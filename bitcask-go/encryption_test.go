@@ -0,0 +1,156 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fileKeyIDs 统计数据文件使用的密钥id，明文文件记为-1
+func fileKeyIDs(db *DB) map[int]int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	ids := make(map[int]int)
+	keyID, ok := db.activeFile.KeyID()
+	if !ok {
+		ids[-1]++
+	} else {
+		ids[int(keyID)]++
+	}
+	for _, file := range db.olderFiles {
+		if keyID, ok = file.KeyID(); !ok {
+			ids[-1]++
+		} else {
+			ids[int(keyID)]++
+		}
+	}
+	return ids
+}
+
+// dirContains 数据目录中是否有文件包含data
+func dirContains(t *testing.T, dir string, data []byte) bool {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		if bytes.Contains(content, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Encryption(t *testing.T) {
+	keys := &KeyRing{CurrentID: 1, Keys: map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)}}
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-encryption"
+	opts.DataFileSize = 32 * 1024
+	opts.MergeRatioThreshold = 0
+	opts.DataFileHint = true
+	opts.IndexSnapshot = true
+	opts.Encryption = keys
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	secret := []byte("top-secret-value")
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), append(secret, byte(i))))
+	}
+	for i := 0; i < 500; i += 10 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, map[int]int{1: len(db.olderFiles) + 1}, fileKeyIDs(db))
+
+	// 轮换密钥，之后的新文件使用新密钥
+	keys.CurrentID = 2
+	keys.Keys[2] = bytes.Repeat([]byte("2"), 16)
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), append(secret, byte(i))))
+	}
+	ids := fileKeyIDs(db)
+	assert.True(t, ids[1] > 0 && ids[2] > 0)
+
+	// merge用新密钥重新加密所有旧文件
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fileKeyIDs(db)[1])
+	assert.Equal(t, uint(950), db.Stat().KeyNum)
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i < 500 && i%10 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, append(secret, byte(i)), val)
+	}
+	assert.Nil(t, db.Close())
+
+	// 数据文件、hint文件、索引快照中都没有明文
+	assert.False(t, dirContains(t, opts.DirPath, secret))
+	assert.False(t, dirContains(t, opts.DirPath, utils.GetTestKey(999)))
+
+	// 密钥错误、缺少密钥时打开失败，并返回明确的错误
+	wrongOpts := opts
+	wrongOpts.Encryption = &KeyRing{CurrentID: 2, Keys: map[uint32][]byte{2: bytes.Repeat([]byte("x"), 16)}}
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrWrongEncryptionKey, err)
+	wrongOpts.Encryption = nil
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+	wrongOpts.Encryption = &KeyRing{CurrentID: 1, Keys: map[uint32][]byte{1: bytes.Repeat([]byte("1"), 32)}}
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrEncryptionKeyNotFound, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	_, err = Open(Options{DirPath: "/tmp/kv/DB-encryption-bptree", IndexType: BPTree, Encryption: keys})
+	assert.NotNil(t, err)
+}
+
+func TestDB_Encryption_EnableOnPlaintext(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-encryption-plaintext"
+	opts.DataFileSize = 32 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 已有的明文文件照常读取，新写入的文件加密，merge后全部加密
+	opts.Encryption = &KeyRing{CurrentID: 7, Keys: map[uint32][]byte{7: bytes.Repeat([]byte("7"), 32)}}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	expected, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	ids := fileKeyIDs(db)
+	assert.True(t, ids[-1] > 0 && ids[7] > 0)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fileKeyIDs(db)[-1])
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, val)
+	assert.Equal(t, uint(1000), db.Stat().KeyNum)
+}
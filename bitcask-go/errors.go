@@ -1,6 +1,9 @@
 package bitcask_go
 
-import "errors"
+import (
+	"bitcask-go/data"
+	"errors"
+)
 
 var (
 	ErrKeyIsEmpty                 = errors.New("the key is empty")
@@ -13,4 +16,20 @@ var (
 	ErrDataBaseIsBeingUsed        = errors.New("database directory is being used")
 	ErrMergeRatioUnreached        = errors.New("the merge ratio do not reach the threshold in options")
 	ErrNotHaveEnoughSpaceForMerge = errors.New("not enough disc space for merge")
+	ErrInvalidTTL                 = errors.New("ttl should be greater than 0")
+	ErrSnapshotReleased           = errors.New("the snapshot has been released")
+	ErrSnapshotNotSupported       = errors.New("snapshot is not supported by the B+ tree index")
+	ErrTxnConflict                = errors.New("transaction conflict, the keys it read have been modified")
+	ErrTxnFinished                = errors.New("the transaction has been committed or rolled back")
+	ErrDataFileHintMismatch       = errors.New("the hint file does not match its data file")
+	ErrIndexSnapshotCorrupted     = errors.New("the index snapshot is corrupted")
+	ErrIndexSnapshotStale         = errors.New("the index snapshot does not match the data files")
+	ErrDataBaseIsReadOnly         = errors.New("the database is opened in read-only mode")
+	ErrWrongEncryptionKey         = data.ErrWrongEncryptionKey
+	ErrEncryptionKeyRequired      = data.ErrEncryptionKeyRequired
+	ErrEncryptionKeyNotFound      = data.ErrEncryptionKeyNotFound
+	ErrUnsupportedFileVersion     = data.ErrUnsupportedFileVersion
+	ErrFileHeaderCorrupted        = data.ErrFileHeaderCorrupted
+	ErrInvalidCRC                 = data.ErrInvalidCRC
+	ErrRecordTruncated            = data.ErrRecordTruncated
 )
@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+// writeVersion0DataFile 按没有文件头的旧格式写一个数据文件
+func writeVersion0DataFile(t *testing.T, dir string, fid uint32, from, to int) {
+	var buf []byte
+	for i := from; i < to; i++ {
+		encoded, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   encodeKeyWithSeqNo(utils.GetTestKey(i), NonTransaction),
+			Value: utils.GetTestKey(i),
+		})
+		buf = append(buf, encoded...)
+	}
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, fid), buf, 0644))
+}
+
+// fileVersions 统计数据文件的格式版本
+func fileVersions(db *DB) map[uint16]int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	versions := map[uint16]int{db.activeFile.Header.Version: 1}
+	for _, file := range db.olderFiles {
+		versions[file.Header.Version]++
+	}
+	return versions
+}
+
+func TestDB_FileHeader_Version0(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-file-header-v0"
+	opts.MergeRatioThreshold = 0
+	assert.Nil(t, os.MkdirAll(opts.DirPath, os.ModePerm))
+	writeVersion0DataFile(t, opts.DirPath, 1, 0, 100)
+	writeVersion0DataFile(t, opts.DirPath, 2, 100, 200)
+
+	// 旧格式的数据目录可以直接打开，之后新建的文件带文件头
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(200), db.Stat().KeyNum)
+	assert.Equal(t, map[uint16]int{0: 2}, fileVersions(db))
+	for i := 200; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// merge把旧文件重写为当前的格式
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fileVersions(db)[0])
+	for i := 0; i < 300; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestDB_FileHeader_Invalid(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-file-header-invalid"
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Close())
+	defer os.RemoveAll(opts.DirPath)
+
+	// 文件头中的id与文件名不一致
+	assert.Nil(t, os.Rename(data.GetDataFileName(opts.DirPath, 1), data.GetDataFileName(opts.DirPath, 5)))
+	_, err = Open(opts)
+	assert.Equal(t, ErrDataFileDirectoryCorrupted, err)
+	assert.Nil(t, os.Rename(data.GetDataFileName(opts.DirPath, 5), data.GetDataFileName(opts.DirPath, 1)))
+
+	// 更新的版本写入的文件
+	file, err := data.NewFile(data.GetDataFileName(opts.DirPath, 2), 2, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	content, err := os.ReadFile(data.GetDataFileName(opts.DirPath, 2))
+	assert.Nil(t, err)
+	// 版本号加一，并重新计算文件头的crc
+	binary.LittleEndian.PutUint16(content[4:6], data.FileFormatVersion+1)
+	binary.LittleEndian.PutUint32(content[20:24], crc32.ChecksumIEEE(content[:20]))
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(opts.DirPath, 2), content, 0644))
+	_, err = Open(opts)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+}
@@ -20,6 +20,15 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{f: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 以只读方式打开已经存在的文件
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{f: fd}, nil
+}
+
 // 根据offset读取
 // ReadAt always returns a non-nil error when n < len(b). At end of file, that error is io.EOF.
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
@@ -95,3 +95,28 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestNewReadOnlyFileIOManager(t *testing.T) {
+	path := filepath.Join("/tmp/kv", "0001.data")
+	// 文件不存在时不会创建
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(path)
+	defer destroyFile(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	roFio, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	n, err := roFio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b[:n])
+	// 不能写入
+	_, err = roFio.Write([]byte("key-b"))
+	assert.NotNil(t, err)
+	assert.Nil(t, roFio.Close())
+	assert.Nil(t, fio.Close())
+}
@@ -10,6 +10,8 @@ const (
 	StandardFIO FileIOType = iota
 	// MemoryMapIO 内存文件映射
 	MemoryMapIO
+	// ReadOnlyFIO 以只读方式打开的标准文件IO，文件不存在时不会创建
+	ReadOnlyFIO
 )
 
 // IOManager 提供抽象 IO 管理接口，后期可以接入不同的 IO 类型，目前支持标准文件 IO
@@ -37,6 +39,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMapIO:
 		return NewMMap(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
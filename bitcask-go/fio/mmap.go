@@ -2,7 +2,6 @@ package fio
 
 import (
 	"golang.org/x/exp/mmap"
-	"os"
 )
 
 // MMap 用于启动加速(用了官方扩展包，只能用来读取数据)
@@ -11,12 +10,8 @@ type MMap struct {
 }
 
 // NewMMap 初始化MMap IO
+// 只用于读取已经存在的文件：以只读方式打开，文件不存在时返回错误，映射完成后文件描述符随即关闭
 func NewMMap(fileName string) (*MMap, error) {
-	_, err := os.OpenFile(fileName, os.O_CREATE, DataFilePerm)
-	if err != nil {
-		return nil, err
-	}
-
 	readerAt, err := mmap.Open(fileName)
 	if err != nil {
 		return nil, err
@@ -1,9 +1,35 @@
 package fio
 
 import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestMMap_Size(t *testing.T) {
 
 }
+
+func TestNewMMap(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-a.data")
+	defer destroyFile(path)
+
+	// 文件不存在时不会创建
+	_, err := NewMMap(path)
+	assert.NotNil(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, os.WriteFile(path, []byte("key-a"), DataFilePerm))
+	mmapIO, err := NewMMap(path)
+	assert.Nil(t, err)
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+	b := make([]byte, 5)
+	_, err = mmapIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+	assert.Nil(t, mmapIO.Close())
+}
@@ -9,12 +9,15 @@ require (
 	github.com/dgraph-io/badger v1.6.2
 	github.com/gin-gonic/gin v1.9.1
 	github.com/gofrs/flock v0.8.1
+	github.com/golang/snappy v0.0.4
 	github.com/google/btree v1.1.2
+	github.com/klauspost/compress v1.15.15
 	github.com/plar/go-adaptive-radix-tree v1.0.5
 	github.com/rosedblabs/rosedb/v2 v2.3.1
 	github.com/stretchr/testify v1.8.4
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/tidwall/redcon v1.6.2
+	go.etcd.io/bbolt v1.3.7
 	golang.org/x/exp v0.0.0-20230713183714-613f0c0eb8a1
 )
 
@@ -43,10 +46,8 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/glog v1.0.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.4 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/klauspost/compress v1.15.15 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.4 // indirect
 	github.com/kr/pretty v0.2.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
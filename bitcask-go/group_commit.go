@@ -0,0 +1,102 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"sync"
+)
+
+// commitRequest 一个等待组提交的写操作
+type commitRequest struct {
+	fn   func() error  // 在持有db锁的情况下执行的写操作
+	err  error         // 写操作或者持久化的错误
+	done bool          // 是否已经由leader处理完成
+	wake chan struct{} // leader处理完成，或者轮到它成为leader时通知
+}
+
+// groupCommitter 组提交：并发的同步写操作先排队，由一个leader依次写入数据文件后只做一次持久化，
+// 再唤醒同一组的所有写操作
+type groupCommitter struct {
+	mu      sync.Mutex
+	queue   []*commitRequest // 等待下一组提交的写操作
+	leading bool             // 当前是否有leader正在提交
+}
+
+// commitWrite 执行一次写操作。需要持久化时通过组提交与其他并发的同步写操作共用一次fsync，
+// 否则直接在db锁内执行（只读模式下写操作都会失败，也不需要持久化）
+func (db *DB) commitWrite(syncWrites bool, fn func() error) error {
+	if !syncWrites || db.options.ReadOnly {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return fn()
+	}
+
+	req := &commitRequest{fn: fn, wake: make(chan struct{}, 1)}
+	c := db.committer
+	c.mu.Lock()
+	c.queue = append(c.queue, req)
+	if c.leading {
+		// 等待当前的leader把自己所在的一组提交完成
+		c.mu.Unlock()
+		<-req.wake
+		if req.done {
+			return req.err
+		}
+		// 上一个leader把leader的身份交给了自己
+		c.mu.Lock()
+	} else {
+		c.leading = true
+	}
+	group := c.queue
+	c.queue = nil
+	c.mu.Unlock()
+
+	db.commitGroup(group)
+
+	// 把leader的身份交给下一组的第一个写操作，然后唤醒本组的其他写操作
+	c.mu.Lock()
+	var next *commitRequest
+	if len(c.queue) > 0 {
+		next = c.queue[0]
+	} else {
+		c.leading = false
+	}
+	c.mu.Unlock()
+	if next != nil {
+		next.wake <- struct{}{}
+	}
+	for _, r := range group {
+		if r != req {
+			r.wake <- struct{}{}
+		}
+	}
+	return req.err
+}
+
+// commitGroup 在db锁内依次执行一组写操作，最后只对活跃文件做一次持久化
+// 持久化完成之前不会释放db锁，Get不会读到还没有持久化的数据
+// 磁盘索引在一个bolt事务中完成整组的索引修改，事务失败时整组的写操作都返回错误
+func (db *DB) commitGroup(group []*commitRequest) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.deferSync = true
+	err := db.updateIndex(func(index.Writer) error {
+		for _, req := range group {
+			req.err = req.fn()
+		}
+		return nil
+	})
+	db.deferSync = false
+
+	if db.activeFile != nil {
+		if err1 := db.syncActiveFileWithoutLock(); err == nil {
+			err = err1
+		}
+	}
+	for _, req := range group {
+		if req.err == nil {
+			req.err = err
+		}
+		req.done = true
+	}
+}
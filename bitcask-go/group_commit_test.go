@@ -0,0 +1,95 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, BPTree} {
+		t.Run(fmt.Sprintf("index-%d", indexType), func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = "/tmp/kv/DB-group-commit"
+			opts.DataFileSize = 64 * 1024
+			opts.SyncWrites = true
+			opts.IndexType = indexType
+			db, err := Open(opts)
+			defer func() { destroyDB(db) }()
+			assert.Nil(t, err)
+
+			// 并发的同步写入、删除以及批量写入（RandomValue不是并发安全的，提前生成value）
+			value := utils.RandomValue(128)
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := w * 100; i < (w+1)*100; i++ {
+						assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+					}
+					for i := w * 100; i < w*100+20; i++ {
+						assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+					}
+					wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+					for i := 1000 + w*10; i < 1000+(w+1)*10; i++ {
+						assert.Nil(t, wb.PendingPut(utils.GetTestKey(i), value))
+					}
+					assert.Nil(t, wb.Commit())
+				}(w)
+			}
+			wg.Wait()
+			assert.Equal(t, uint(8*80+80), db.Stat().KeyNum)
+			assert.False(t, db.committer.leading)
+			assert.Equal(t, 0, len(db.committer.queue))
+
+			// 重启后数据不变
+			err = db.Close()
+			assert.Nil(t, err)
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, uint(8*80+80), db.Stat().KeyNum)
+			_, err = db.Get(utils.GetTestKey(0))
+			assert.Equal(t, ErrKeyNotFound, err)
+			val, err := db.Get(utils.GetTestKey(1075))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		})
+	}
+}
+
+func TestDB_GroupCommit_Error(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-group-commit-error"
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 同一组中某个写操作失败，不影响其他写操作
+	errWrite := errors.New("write failed")
+	value := utils.RandomValue(24)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := db.commitWrite(true, func() error {
+				if i%5 == 0 {
+					return errWrite
+				}
+				return db.putWithoutLock(utils.GetTestKey(i), value, 0)
+			})
+			if i%5 == 0 {
+				assert.Equal(t, errWrite, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, uint(40), db.Stat().KeyNum)
+}
@@ -0,0 +1,243 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// dataFileHintHeaderKey 数据文件hint的第一条记录的key，value是生成hint时数据文件的大小
+var dataFileHintHeaderKey = []byte("data-file-size")
+
+// writeDataFileHint 为一个已经写满（不会再被写入）的数据文件生成hint文件
+// 先写入临时文件，持久化后再重命名，保证hint文件要么是完整的，要么不存在
+func (db *DB) writeDataFileHint(dataFile *data.File) error {
+	dataSize, err := dataFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+
+	hintName := data.GetDataFileHintName(db.options.DirPath, dataFile.Fid)
+	tmpName := hintName + ".tmp"
+	// 上次没写完的临时文件直接删掉（文件是以追加方式打开的）
+	if err = os.Remove(tmpName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	hintFile, err := db.openFile(tmpName, dataFile.Fid, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = hintFile.Close()
+		_ = os.Remove(tmpName)
+	}()
+
+	// 第一条记录是数据文件的大小，加载时用来确认hint与数据文件是对应的
+	header, _ := hintFile.EncodeLogRecord(&data.LogRecord{
+		Key:   dataFileHintHeaderKey,
+		Value: []byte(strconv.FormatInt(dataSize, 10)),
+	})
+	if err = hintFile.Write(header); err != nil {
+		return err
+	}
+
+	var offset int64 = 0
+	for offset < dataSize {
+		record, size, err1 := dataFile.ReadLogRecord(offset)
+		if err1 != nil {
+			if err1 == io.EOF {
+				break
+			}
+			return err1
+		}
+		pos := &data.LogRecordPos{Fid: dataFile.Fid, Offset: offset, Size: uint32(size), Expire: record.Expire}
+		if err = hintFile.WriteDataFileHint(record, pos); err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	if err = hintFile.SyncFile(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, hintName)
+}
+
+// readDataFileHint 读取数据文件的hint，得到该文件中每条记录（不含value）及其位置信息
+// hint文件不存在、与数据文件不对应或者校验失败时返回false，此时需要扫描数据文件；校验失败的hint文件会被删除，之后重新生成
+func (db *DB) readDataFileHint(dataFile *data.File) ([]*data.TransactionRecord, bool) {
+	hintName := data.GetDataFileHintName(db.options.DirPath, dataFile.Fid)
+	if _, err := os.Stat(hintName); err != nil {
+		return nil, false
+	}
+
+	records, err := db.readDataFileHintRecords(dataFile)
+	if err != nil {
+		log.Printf("invalid hint file for data file %d, fall back to scanning: %v", dataFile.Fid, err)
+		// 删掉后会在后台重新生成（只读模式下不修改数据目录）
+		if !db.options.ReadOnly {
+			_ = os.Remove(hintName)
+		}
+		return nil, false
+	}
+	return records, true
+}
+
+func (db *DB) readDataFileHintRecords(dataFile *data.File) ([]*data.TransactionRecord, error) {
+	hintFile, err := db.openFile(data.GetDataFileHintName(db.options.DirPath, dataFile.Fid), dataFile.Fid, fio.ReadOnlyFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+
+	header, offset, err := hintFile.ReadLogRecord(0)
+	if err == io.EOF || (err == nil && !bytes.Equal(header.Key, dataFileHintHeaderKey)) {
+		return nil, ErrDataFileHintMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataSize, err := dataFile.IOManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if string(header.Value) != strconv.FormatInt(dataSize, 10) {
+		return nil, ErrDataFileHintMismatch
+	}
+
+	var records []*data.TransactionRecord
+	for {
+		record, size, err1 := hintFile.ReadLogRecord(offset)
+		if err1 != nil {
+			if err1 == io.EOF {
+				break
+			}
+			return nil, err1
+		}
+		pos := data.DecodeLogRecordPos(record.Value)
+		record.Value = nil
+		records = append(records, &data.TransactionRecord{Record: record, Position: pos})
+		offset += size
+	}
+	return records, nil
+}
+
+// hintQueue 等待生成hint文件的数据文件。hint文件由一个后台worker依次生成，它在Open时启动、Close时退出
+type hintQueue struct {
+	mu      *sync.Mutex
+	sealed  []*data.File  // 本次打开后写满的文件以及merge生成的文件，Close时会等它们的hint全部写完
+	backlog []*data.File  // 打开时发现的还没有hint的文件，Close时不再等待，下次打开时继续
+	ready   chan struct{} // 有文件加入队列时通知worker
+}
+
+func newHintQueue() *hintQueue {
+	return &hintQueue{mu: new(sync.Mutex), ready: make(chan struct{}, 1)}
+}
+
+// writeDataFileHintsInBackground 把已经写满的数据文件加入队列，由后台worker依次生成hint文件
+// backlog为true表示打开时就已经存在的文件，数据库关闭时不必等待它们
+func (db *DB) writeDataFileHintsInBackground(files []*data.File, backlog bool) {
+	if len(files) == 0 {
+		return
+	}
+	db.hints.mu.Lock()
+	if backlog {
+		db.hints.backlog = append(db.hints.backlog, files...)
+	} else {
+		db.hints.sealed = append(db.hints.sealed, files...)
+	}
+	db.hints.mu.Unlock()
+	select {
+	case db.hints.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dropDataFileHints 从队列中移除将被关闭的数据文件，merge替换数据文件时调用
+// *************** 访问此方法前必须持有hintMu ******************
+func (db *DB) dropDataFileHints(drop func(file *data.File) bool) {
+	db.hints.mu.Lock()
+	defer db.hints.mu.Unlock()
+	filter := func(files []*data.File) []*data.File {
+		kept := files[:0]
+		for _, file := range files {
+			if !drop(file) {
+				kept = append(kept, file)
+			}
+		}
+		return kept
+	}
+	db.hints.sealed = filter(db.hints.sealed)
+	db.hints.backlog = filter(db.hints.backlog)
+}
+
+// runHintWriter 依次为队列中的数据文件生成hint文件，直到db关闭
+// 关闭时先写完本次打开后写满的文件的hint（Close会等待），打开时遗留的文件留到下次打开
+func (db *DB) runHintWriter() {
+	defer db.bgWorkers.Done()
+	closed := func() bool {
+		select {
+		case <-db.closed:
+			return true
+		default:
+			return false
+		}
+	}
+	for {
+		select {
+		case <-db.closed:
+			for db.writeNextDataFileHint(false) {
+			}
+			return
+		case <-db.hints.ready:
+			// 每写完一个文件检查一次是否已经关闭
+			for !closed() && db.writeNextDataFileHint(true) {
+			}
+		}
+	}
+}
+
+// writeNextDataFileHint 为队列中的下一个数据文件生成hint文件，队列为空时返回false
+// 持有hintMu时才从队列中取出文件，merge关闭的文件已经从队列中移除，不会被使用
+func (db *DB) writeNextDataFileHint(withBacklog bool) bool {
+	db.hintMu.Lock()
+	defer db.hintMu.Unlock()
+
+	var file *data.File
+	db.hints.mu.Lock()
+	if len(db.hints.sealed) > 0 {
+		file, db.hints.sealed = db.hints.sealed[0], db.hints.sealed[1:]
+	} else if withBacklog && len(db.hints.backlog) > 0 {
+		file, db.hints.backlog = db.hints.backlog[0], db.hints.backlog[1:]
+	}
+	db.hints.mu.Unlock()
+	if file == nil {
+		return false
+	}
+
+	if err := db.writeDataFileHint(file); err != nil {
+		log.Printf("failed to write hint file for data file %d: %v", file.Fid, err)
+	}
+	return true
+}
+
+// filesWithoutHint 找出还没有hint文件的旧数据文件（例如升级前就存在的文件，或者上次没来得及生成）
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) filesWithoutHint() []*data.File {
+	var files []*data.File
+	for fid, file := range db.olderFiles {
+		if _, err := os.Stat(data.GetDataFileHintName(db.options.DirPath, fid)); os.IsNotExist(err) {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Fid < files[j].Fid
+	})
+	return files
+}
@@ -0,0 +1,117 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeHintTestData(t *testing.T, db *DB) {
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 500; i < 1000; i++ {
+		err := wb.PendingPut(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err := wb.Commit()
+	assert.Nil(t, err)
+	for i := 3000; i < 4000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_DataFileHint(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-datafile-hint"
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	writeHintTestData(t, db)
+	stat := db.Stat()
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.每个旧文件都生成了hint文件，活跃文件没有
+	olderNum := int(stat.DataFileNum) - 1
+	assert.True(t, olderNum > 1)
+	for fid := 1; fid <= olderNum; fid++ {
+		_, err = os.Stat(data.GetDataFileHintName(opts.DirPath, uint32(fid)))
+		assert.Nil(t, err)
+	}
+	_, err = os.Stat(data.GetDataFileHintName(opts.DirPath, uint32(olderNum+1)))
+	assert.True(t, os.IsNotExist(err))
+
+	// 2.从hint文件加载，与扫描数据文件的结果一致
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(600), val)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 3.hint文件损坏时退回到扫描数据文件，之后重新生成hint文件
+	hintName := data.GetDataFileHintName(opts.DirPath, 1)
+	hint, err := os.ReadFile(hintName)
+	assert.Nil(t, err)
+	hint[len(hint)-3] ^= 0xff
+	err = os.WriteFile(hintName, hint, 0644)
+	assert.Nil(t, err)
+	// 缺失的hint文件同样会被重新生成
+	err = os.Remove(data.GetDataFileHintName(opts.DirPath, 2))
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+	for fid := 1; fid <= 2; fid++ {
+		hintName = data.GetDataFileHintName(opts.DirPath, uint32(fid))
+		assert.Eventually(t, func() bool {
+			_, err1 := os.Stat(hintName)
+			return err1 == nil
+		}, time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestDB_DataFileHintQueue(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-datafile-hint-queue"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	writeHintTestData(t, db)
+
+	// 没有启动worker，文件留在队列中；merge关闭旧文件时把它们从队列中移除
+	db.mu.RLock()
+	var files []*data.File
+	for _, file := range db.olderFiles {
+		files = append(files, file)
+	}
+	db.mu.RUnlock()
+	assert.True(t, len(files) > 1)
+	db.writeDataFileHintsInBackground(files[:1], false)
+	db.writeDataFileHintsInBackground(files[1:], true)
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, 0, len(db.hints.sealed))
+	assert.Equal(t, 0, len(db.hints.backlog))
+}
@@ -0,0 +1,406 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+/*
+	增量merge：只重写无效数据比例最高的几个旧文件，代价与要回收的无效数据量成正比
+
+	每个文件原地重写：有效记录按原来的顺序写入一个同id的新文件，再用它替换原文件。
+	重写后的文件在加载索引时与原文件是等价的，所以每个文件都可以单独替换，不需要等其他文件。
+	与完整merge不同，更早的文件还在，下面两类记录不能简单丢弃：
+	1. 墓碑值：更早的文件中可能还有这个key的旧数据，key当前不存在时需要保留
+	2. 文件开头跨文件事务的完成标识：这个事务的一部分记录在上一个文件中，需要靠它才能生效
+	3. 已过期的记录：加载索引时它和墓碑值一样会删掉这个key，key当前不存在时改写成墓碑值保留
+*/
+
+// compactFileSuffix 重写中的数据文件的后缀，写完后重命名为数据文件
+const compactFileSuffix = ".compact"
+
+// FileStat 单个数据文件的统计信息
+type FileStat struct {
+	Fid             uint32
+	Size            int64   // 文件大小
+	ReclaimableSize int64   // 文件中无效数据的数据量
+	GarbageRatio    float32 // 无效数据所占的比例
+	Active          bool    // 是否是当前活跃文件
+}
+
+// FileStats 统计每个数据文件中的无效数据，按文件id从小到大排序
+func (db *DB) FileStats() ([]FileStat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	files := make([]*data.File, 0, len(db.olderFiles)+1)
+	for _, file := range db.olderFiles {
+		files = append(files, file)
+	}
+	if db.activeFile != nil {
+		files = append(files, db.activeFile)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Fid < files[j].Fid
+	})
+
+	stats := make([]FileStat, 0, len(files))
+	for _, file := range files {
+		size, err := file.IOManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		stat := FileStat{
+			Fid:             file.Fid,
+			Size:            size,
+			ReclaimableSize: db.fileInvalidSize[file.Fid],
+			Active:          file == db.activeFile,
+		}
+		if size > 0 {
+			stat.GarbageRatio = float32(stat.ReclaimableSize) / float32(size)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// mergeIncrementally 挑出无效数据比例达到阈值的旧文件，从比例最高的开始逐个重写
+func (db *DB) mergeIncrementally(ctx context.Context, opts MergeOptions) error {
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+
+	// 已过期的key先从索引中移除，计入无效数据
+	if err := db.removeExpiredKeysWithoutLock(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
+	files, err := db.pickFilesToCompact()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if len(files) == 0 {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+
+	// 文件是逐个重写的，磁盘上只需要容纳其中最大的一个文件的有效数据
+	var maxLiveSize int64
+	for _, file := range files {
+		size, err1 := file.IOManager.Size()
+		if err1 != nil {
+			db.mu.Unlock()
+			return err1
+		}
+		if liveSize := size - db.fileInvalidSize[file.Fid]; liveSize > maxLiveSize {
+			maxLiveSize = liveSize
+		}
+	}
+	availableDiscSize, err := utils.AvailableDiscSize()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if maxLiveSize >= availableDiscSize {
+		db.mu.Unlock()
+		return ErrNotHaveEnoughSpaceForMerge
+	}
+
+	db.isMerging = true
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	tracker := newMergeTracker(ctx, opts, len(files))
+	for _, file := range files {
+		if err = db.compactDataFile(tracker, file); err != nil {
+			return err
+		}
+		tracker.fileDone()
+	}
+	return nil
+}
+
+// pickFilesToCompact 按无效数据比例从高到低挑选需要重写的旧文件
+// 被快照引用的文件，以及后台可能还在为它生成hint文件的文件不参与
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) pickFilesToCompact() ([]*data.File, error) {
+	type candidate struct {
+		file  *data.File
+		ratio float32
+	}
+	var candidates []candidate
+	for fid, file := range db.olderFiles {
+		if db.filePins[fid] > 0 {
+			continue
+		}
+		if db.options.DataFileHint {
+			if _, err := os.Stat(data.GetDataFileHintName(db.options.DirPath, fid)); err != nil {
+				continue
+			}
+		}
+		size, err := file.IOManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		invalidSize := db.fileInvalidSize[fid]
+		if size == 0 || invalidSize == 0 {
+			continue
+		}
+		if ratio := float32(invalidSize) / float32(size); ratio >= db.options.MergeRatioThreshold {
+			candidates = append(candidates, candidate{file: file, ratio: ratio})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].ratio != candidates[j].ratio {
+			return candidates[i].ratio > candidates[j].ratio
+		}
+		return candidates[i].file.Fid < candidates[j].file.Fid
+	})
+	if maxFiles := db.options.IncrementalMergeMaxFiles; maxFiles > 0 && len(candidates) > maxFiles {
+		candidates = candidates[:maxFiles]
+	}
+
+	files := make([]*data.File, len(candidates))
+	for i, c := range candidates {
+		files[i] = c.file
+	}
+	return files, nil
+}
+
+// movedRecord merge时被搬到新文件中的一条记录
+type movedRecord struct {
+	key       []byte
+	oldFid    uint32
+	oldOffset int64
+	pos       *data.LogRecordPos
+}
+
+// compactDataFile 重写一个旧文件：先在不持有锁的情况下写出新文件，再在锁内替换原文件并更新索引
+// 重写中途出错或被取消时，删除写了一半的新文件，原文件不受影响
+func (db *DB) compactDataFile(tracker *mergeTracker, file *data.File) error {
+	db.mu.RLock()
+	hasOlder := false
+	for fid := range db.olderFiles {
+		if fid < file.Fid {
+			hasOlder = true
+			break
+		}
+	}
+	db.mu.RUnlock()
+
+	size, err := file.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	tmpName := data.GetDataFileName(db.options.DirPath, file.Fid) + compactFileSuffix
+	if err = os.Remove(tmpName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	compacted, err := db.openFile(tmpName, file.Fid, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	installed := false
+	defer func() {
+		if !installed {
+			_ = compacted.Close()
+			_ = os.Remove(tmpName)
+		}
+	}()
+
+	var moved []movedRecord
+	var keptInvalidSize int64 // 保留下来的墓碑值和事务完成标识，它们在新文件中依然是无效数据
+	var offset int64 = 0
+	// 文件开头那个事务的序列号，它可能是从上一个文件开始的
+	headSeqNo, inHeadTxn := NonTransaction, true
+	for {
+		record, recordSize, err1 := file.ReadLogRecord(offset)
+		if err1 != nil {
+			if err1 == io.EOF {
+				break
+			}
+			return err1
+		}
+		realKey, seqNo := decodeKeyWithSeqNo(record.Key)
+		if offset == 0 {
+			headSeqNo = seqNo
+		}
+		if seqNo == NonTransaction || seqNo != headSeqNo {
+			inHeadTxn = false
+		}
+
+		keep, kept := false, int64(0)
+		switch record.Type {
+		case data.TransactionFinished:
+			keep = hasOlder && inHeadTxn
+			inHeadTxn = false
+		case data.LogRecordDeleted:
+			keep = hasOlder && db.index.Get(realKey) == nil
+			record.Key = encodeKeyWithSeqNo(realKey, NonTransaction)
+		default:
+			// 已过期但还在索引中的记录也要保留，索引里的位置需要跟着更新
+			pos := db.index.Get(realKey)
+			keep = pos != nil && pos.Fid == file.Fid && pos.Offset == offset
+			record.Key = encodeKeyWithSeqNo(realKey, NonTransaction)
+			// 已经从索引中移除的过期记录起着墓碑值的作用，改写成墓碑值保留下来
+			if !keep && hasOlder && pos == nil && record.IsExpired() {
+				keep = true
+				record.Type, record.Value, record.Expire = data.LogRecordDeleted, nil, 0
+			}
+		}
+
+		if keep {
+			// 按当前配置的压缩方式重新压缩
+			if err = db.compressRecord(record); err != nil {
+				return err
+			}
+			encoded, newSize := compacted.EncodeLogRecord(record)
+			newPos := &data.LogRecordPos{Fid: file.Fid, Offset: compacted.WriteOffset, Size: uint32(newSize), Expire: record.Expire}
+			if err = compacted.Write(encoded); err != nil {
+				return err
+			}
+			kept = newSize
+			if record.Type == data.LogRecordNormal {
+				moved = append(moved, movedRecord{key: append([]byte(nil), realKey...), oldFid: file.Fid, oldOffset: offset, pos: newPos})
+			} else {
+				keptInvalidSize += newSize
+			}
+		}
+		offset += recordSize
+		if err = tracker.record(recordSize, kept, keep && record.Type == data.LogRecordNormal); err != nil {
+			return err
+		}
+	}
+
+	// 没有可以回收的空间
+	if compacted.WriteOffset >= size {
+		return nil
+	}
+	if err = compacted.SyncFile(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	db.hintMu.Lock()
+	installed, err = db.installCompactedFile(file, compacted, tmpName, moved, keptInvalidSize)
+	db.hintMu.Unlock()
+	db.mu.Unlock()
+	if err != nil || !installed {
+		return err
+	}
+
+	if db.options.DataFileHint && compacted.WriteOffset > 0 {
+		db.hintMu.Lock()
+		if err = db.writeDataFileHint(compacted); err != nil {
+			log.Printf("failed to write hint file for data file %d: %v", compacted.Fid, err)
+		}
+		db.hintMu.Unlock()
+	}
+	return nil
+}
+
+// installCompactedFile 用重写后的文件替换原文件，并把仍然指向原文件的索引改为指向新文件
+// 返回重写后的文件是否已经接管（新文件为空时原文件直接删除，新文件不再需要）
+// *************** 访问此方法前必须持有互斥锁和hintMu ******************
+func (db *DB) installCompactedFile(file, compacted *data.File, tmpName string,
+	moved []movedRecord, keptInvalidSize int64) (bool, error) {
+	// 重写期间创建了快照，原文件被快照引用着，放弃这次重写
+	if db.filePins[file.Fid] > 0 {
+		return false, nil
+	}
+	// 原文件之后会被关闭，不再为它生成hint
+	db.dropDataFileHints(func(f *data.File) bool {
+		return f == file
+	})
+	if err := db.removeMergeHint(file.Fid); err != nil {
+		return false, err
+	}
+	if err := os.Remove(data.GetDataFileHintName(db.options.DirPath, file.Fid)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	fileName := data.GetDataFileName(db.options.DirPath, file.Fid)
+	if compacted.WriteOffset == 0 {
+		// 文件中已经没有需要保留的记录
+		if err := os.Remove(fileName); err != nil {
+			return false, err
+		}
+		delete(db.olderFiles, file.Fid)
+		_ = file.Close()
+		db.invalidSize -= db.fileInvalidSize[file.Fid]
+		delete(db.fileInvalidSize, file.Fid)
+		return false, nil
+	}
+
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return false, err
+	}
+	db.olderFiles[file.Fid] = compacted
+	_ = file.Close()
+
+	db.invalidSize += keptInvalidSize - db.fileInvalidSize[file.Fid]
+	db.fileInvalidSize[file.Fid] = keptInvalidSize
+	if err := db.updateIndex(func(idx index.Writer) error {
+		db.updateMovedIndex(idx, moved)
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// updateMovedIndex 把仍然指向旧位置的索引改为指向搬过去的新位置
+// merge期间被修改过的key不再更新，它们在新文件中的记录计入无效数据
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) updateMovedIndex(idx index.Writer, moved []movedRecord) {
+	for _, record := range moved {
+		pos := idx.Get(record.key)
+		if pos != nil && pos.Fid == record.oldFid && pos.Offset == record.oldOffset {
+			idx.Put(record.key, record.pos)
+		} else {
+			db.addInvalidSize(record.pos)
+		}
+	}
+}
+
+// removeMergeHint 重写的是完整merge生成的文件时，merge的hint文件中的位置不再准确，
+// 删掉merge完成的标识和hint文件，之后打开数据库时直接从数据文件加载索引
+// 先删除标识：只剩下hint文件时，它的记录会被数据文件中的记录覆盖，加载出的索引依然正确
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) removeMergeHint(fid uint32) error {
+	mergeFinishedFile := filepath.Join(db.options.DirPath, data.MergeFinishedFile)
+	if _, err := os.Stat(mergeFinishedFile); err != nil {
+		return nil
+	}
+	firstNonMergedFid, err := db.GetFirstNonMergedFid(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if fid >= firstNonMergedFid {
+		return nil
+	}
+	if err = os.Remove(mergeFinishedFile); err != nil {
+		return err
+	}
+	if err = os.Remove(filepath.Join(db.options.DirPath, data.HintFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// writeIncrementalMergeTestData 写入数据并制造无效数据，返回每个key当前的值（nil表示已被删除）
+func writeIncrementalMergeTestData(t *testing.T, db *DB) map[string][]byte {
+	expected := make(map[string][]byte)
+	for i := 0; i < 3000; i++ {
+		value := utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	// 覆盖写，前几个文件中的数据大部分变为无效
+	for i := 0; i < 1000; i++ {
+		value := utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 1000; i < 1500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		expected[string(utils.GetTestKey(i))] = nil
+	}
+	// 跨越多个文件的事务
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 2000; i < 2600; i++ {
+		value := utils.RandomValue(128)
+		assert.Nil(t, wb.PendingPut(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 2000; i < 2300; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		expected[string(utils.GetTestKey(i))] = nil
+	}
+	return expected
+}
+
+func checkIncrementalMergeTestData(t *testing.T, db *DB, expected map[string][]byte) {
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		if value == nil {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Equal(t, uint(2200), db.Stat().KeyNum)
+}
+
+// checkFileStats 每个文件的无效数据量加起来等于总的无效数据量
+func checkFileStats(t *testing.T, db *DB) {
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	var total int64
+	for i, stat := range stats {
+		total += stat.ReclaimableSize
+		assert.True(t, stat.ReclaimableSize <= stat.Size)
+		assert.Equal(t, i == len(stats)-1, stat.Active)
+		if i > 0 {
+			assert.True(t, stats[i-1].Fid < stat.Fid)
+		}
+	}
+	assert.Equal(t, db.Stat().ReclaimableSize, total)
+}
+
+func TestDB_FileStats(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-file-stats"
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(stats))
+
+	writeIncrementalMergeTestData(t, db)
+	stats, err = db.FileStats()
+	assert.Nil(t, err)
+	assert.True(t, len(stats) > 1)
+	// 第一个文件中的key全部被覆盖过
+	assert.True(t, stats[0].GarbageRatio > 0.9)
+	checkFileStats(t, db)
+
+	// 重启后，每个文件的无效数据量保持不变
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	stats2, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, stats, stats2)
+}
+
+func TestDB_IncrementalMerge(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-incremental-merge"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	opts.IncrementalMerge = true
+	opts.IncrementalMergeMaxFiles = 0
+	opts.MergeRatioThreshold = 0.5
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	statBefore := db.Stat()
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	statAfter := db.Stat()
+	assert.True(t, statAfter.ReclaimableSize < statBefore.ReclaimableSize)
+	assert.True(t, statAfter.OccupiedDiscSize < statBefore.OccupiedDiscSize)
+	checkIncrementalMergeTestData(t, db, expected)
+	checkFileStats(t, db)
+
+	// 没有文件的无效数据比例达到阈值
+	err = db.Merge()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+
+	// 重启后数据不变
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+	checkFileStats(t, db)
+}
+
+func TestDB_IncrementalMerge_MaxFiles(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-incremental-merge-max-files"
+	opts.DataFileSize = 64 * 1024
+	opts.IncrementalMerge = true
+	opts.IncrementalMergeMaxFiles = 1
+	opts.MergeRatioThreshold = 0.5
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	// 还在生成hint文件的旧文件不参与merge
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return len(db.filesWithoutHint()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		err = db.Merge()
+		assert.Nil(t, err)
+		checkIncrementalMergeTestData(t, db, expected)
+		checkFileStats(t, db)
+	}
+	stats2, err := db.FileStats()
+	assert.Nil(t, err)
+	// 每次只重写一个文件（重写后为空的文件会被删除）
+	sizes := make(map[uint32]int64)
+	for _, stat := range stats2 {
+		sizes[stat.Fid] = stat.Size
+	}
+	var changed int
+	for _, stat := range stats {
+		if size, ok := sizes[stat.Fid]; !ok || size != stat.Size {
+			changed++
+		}
+	}
+	assert.True(t, changed >= 1 && changed <= 3)
+
+	// 重写后的文件也有hint文件，重启后可以直接使用
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_IncrementalMerge_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-incremental-merge-snapshot"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	opts.IncrementalMerge = true
+	opts.MergeRatioThreshold = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+
+	// 被快照引用的文件不会被重写
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+	val, err := snap.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, expected[string(utils.GetTestKey(0))], val)
+	snap.Release()
+
+	// 迭代过程中发生merge，依然能读到正确的值
+	iter := db.NewIterator(DefaultIteratorOptions)
+	err = db.Merge()
+	assert.Nil(t, err)
+	var n int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err = iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, expected[string(iter.Key())], val)
+		n++
+	}
+	iter.Close()
+	assert.Equal(t, 2200, n)
+}
+
+func TestDB_IncrementalMerge_AfterMerge(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-incremental-merge-after-merge"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	// 先做一次完整merge
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.IncrementalMerge = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+	// 让merge生成的文件中出现无效数据
+	for i := 1500; i < 2000; i++ {
+		value := utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+
+	// merge的hint文件已经失效，重启后从数据文件加载
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_IncrementalMerge_ExpiredKey(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-incremental-merge-expired"
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileHint = false
+	opts.IncrementalMerge = true
+	opts.IncrementalMergeMaxFiles = 0
+	opts.MergeRatioThreshold = 0.5
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	// 文件1：victim的旧版本和一些有效数据，无效数据比例低，不会被重写
+	assert.Nil(t, db.Put([]byte("victim"), []byte("v1")))
+	for i := 0; db.activeFile.Fid == 1; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	// 文件2：victim设置了过期时间，其余的数据之后都被覆盖，需要被重写
+	assert.Nil(t, db.PutWithTTL([]byte("victim"), []byte("v2"), 10*time.Millisecond))
+	var garbage [][]byte
+	for i := 10000; db.activeFile.Fid == 2; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		garbage = append(garbage, utils.GetTestKey(i))
+	}
+	for _, key := range garbage {
+		assert.Nil(t, db.Put(key, utils.RandomValue(16)))
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Nil(t, db.Merge())
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.True(t, stats[0].GarbageRatio < opts.MergeRatioThreshold)
+	_, err = db.Get([]byte("victim"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启后文件1中的旧版本不能重新出现
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("victim"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
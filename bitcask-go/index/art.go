@@ -4,7 +4,6 @@ import (
 	"bitcask-go/data"
 	"bytes"
 	goART "github.com/plar/go-adaptive-radix-tree"
-	"sort"
 	"sync"
 )
 
@@ -62,95 +61,71 @@ func (art *AdaptiveRadixTree) Size() int {
 	return size
 }
 
+// Iterator 建立索引迭代器
+// 基于goART按批次取数据，不会拷贝整个索引，一致性说明见 cursorIterator
+// 从头正向遍历时接着goART自带的迭代器往下走；goART的迭代器不支持从某个key开始，也不支持反向遍历，
+// 所以 Seek 以及反向遍历按前缀拆分后逐段取出（见 fetchByPrefix、fetchReverseByPrefix），代价与索引大小无关，
+// 但与 key 的长度成正比：每取一批最多要做 256 × key长度 次前缀遍历。key 较长（比如共享很长的前缀）时，
+// Seek 与反向遍历明显慢于 BTree（见 benchmark 中的 *_LongKey），这类场景建议使用 BTree 索引
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-	return newArtIterator(art.tree, reverse)
-}
-
-func (art *AdaptiveRadixTree) Close() error {
-	art.tree = nil
-	art.lock = nil
-	return nil
-}
-
-// artIterator 索引迭代器（不面向用户）
-// 问题：要将items都保存在内存中的values数组里，可能导致内存不够用！！！！
-type artIterator struct {
-	currIndex int     // 当前位置指针：当前遍历到values的下标位置
-	reverse   bool    // 是否是反向遍历
-	values    []*Item // 从内存取出的 key+位置索引 信息
-}
-
-func newArtIterator(t goART.Tree, reverse bool) *artIterator {
-	var i int
+	tree, lock := art.tree, art.lock
+	scan := func(prefix []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+		tree.ForEachPrefix(prefix, func(node goART.Node) bool {
+			// ForEachPrefix 也会遍历到非叶子节点
+			if node.Kind() != goART.Leaf {
+				return true
+			}
+			return fn(node.Key(), node.Value().(*data.LogRecordPos))
+		})
+	}
 	if reverse {
-		i = t.Size() - 1
+		return newCursorIterator(func(start []byte, inclusive bool, n int, buf []*Item) []*Item {
+			lock.RLock()
+			defer lock.RUnlock()
+			return fetchReverseByPrefix(scan, start, inclusive, n, buf)
+		})
 	}
-	values := make([]*Item, t.Size())
-
-	// Callback function type for tree traversal. if the callback function returns false then iteration is terminated.
 
-	saveValues := func(node goART.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+	var (
+		it      goART.Iterator // 上一批用到的goART迭代器，接着上一批继续往下走
+		lastKey []byte         // it 上一次返回的key
+	)
+	return newCursorIterator(func(start []byte, inclusive bool, n int, buf []*Item) []*Item {
+		lock.RLock()
+		defer lock.RUnlock()
+
+		if start == nil {
+			it = tree.Iterator()
+		} else if inclusive || !bytes.Equal(start, lastKey) {
+			it = nil
 		}
-		values[i] = item
-		if reverse {
-			i--
-		} else {
-			i++
+		for it != nil && len(buf) < n && it.HasNext() {
+			node, err := it.Next()
+			if err == goART.ErrConcurrentModification {
+				// 两批之间树被修改过了，从已经取到的位置重新定位
+				it = nil
+				if len(buf) > 0 {
+					start, inclusive = buf[len(buf)-1].key, false
+				}
+				break
+			}
+			if err != nil {
+				break
+			}
+			buf = append(buf, &Item{key: node.Key(), pos: node.Value().(*data.LogRecordPos)})
 		}
-		return true
-	}
-
-	t.ForEach(saveValues)
-
-	return &artIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
-	}
-
-}
-
-func (arti *artIterator) Rewind() {
-	arti.currIndex = 0 // currIndex设置为0，即回到起点
-}
-
-func (arti *artIterator) Seek(key []byte) {
-	// values本身就是有序的
-	// 二分查找
-	if arti.reverse {
-		// currIndex设置为找到第一个小于等于key的item的下标
-		arti.currIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) <= 0 // 找到第一个小于等于key的
-		})
-	} else {
-		arti.currIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) >= 0 // 找到第一个大于等于key的
-		})
-	}
-}
-
-func (arti *artIterator) Next() {
-	arti.currIndex += 1
-}
-
-func (arti *artIterator) IsValid() bool {
-	return arti.currIndex < len(arti.values)
-}
-
-func (arti *artIterator) Key() []byte {
-	return arti.values[arti.currIndex].key
-}
-
-func (arti *artIterator) Value() *data.LogRecordPos {
-	return arti.values[arti.currIndex].pos
+		if it == nil && len(buf) < n {
+			buf = fetchByPrefix(scan, start, inclusive, n, buf)
+		}
+		if len(buf) > 0 {
+			lastKey = buf[len(buf)-1].key
+		}
+		return buf
+	})
 }
 
-func (arti *artIterator) Close() {
-	// 清理掉临时数组
-	arti.values = nil
+func (art *AdaptiveRadixTree) Close() error {
+	art.tree = nil
+	art.lock = nil
+	return nil
 }
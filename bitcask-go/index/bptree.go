@@ -0,0 +1,378 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BPTreeIndexFileName B+树索引在数据目录中的文件名
+const BPTreeIndexFileName = "bptree-index"
+
+var (
+	indexBucketName = []byte("bitcask-index")
+	metaBucketName  = []byte("bitcask-index-meta")
+	checkpointKey   = []byte("checkpoint")
+)
+
+// BPlusTree 磁盘上的B+树索引，封装了 https://github.com/etcd-io/bbolt 库（boltdb/bolt 在 -race 下无法通过 checkptr 检查）
+// 索引存放在数据目录下的 bptree-index 文件中，key的数量不再受内存大小的限制
+// 数据库的每次写入（一个WriteBatch、一个事务）通过 Update 在一个bolt事务中完成所有索引修改，并同时保存检查点，
+// 所以索引和检查点总是一致的：进程崩溃后重新打开时，只需要重放检查点之后的记录。
+// 为了写入性能，事务提交时不做 fsync，操作系统崩溃后索引文件可能损坏，此时删掉重建。
+type BPlusTree struct {
+	tree *bolt.DB
+	lock *sync.RWMutex // 保护 size 和 err
+	size int           // key的数量，避免每次都遍历整棵树统计
+	err  error         // 直接调用 Put / Delete 时写入失败的错误，由之后的 Update / SaveCheckpoint 返回
+}
+
+// Checkpoint 磁盘索引的检查点，记录索引已经包含了哪些数据
+type Checkpoint struct {
+	Fid         uint32 // Fid 号数据文件 Offset 之前（以及更早的文件）的记录都已经反映在索引中
+	Offset      int64
+	SeqNo       uint64 // 当时的事务序列号
+	InvalidSize int64  // 当时的无效数据量
+
+	FileInvalidSize map[uint32]int64 // 当时每个数据文件中的无效数据量
+}
+
+// NewBPlusTree 打开（或创建）dirPath目录下的B+树索引
+// 索引文件损坏时（例如操作系统崩溃）直接删掉重建，它可以完全由数据文件恢复
+func NewBPlusTree(dirPath string) (*BPlusTree, error) {
+	fileName := filepath.Join(dirPath, BPTreeIndexFileName)
+	tree, err := openBoltTree(fileName)
+	if err != nil {
+		if err = os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if tree, err = openBoltTree(fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	bpt := &BPlusTree{tree: tree, lock: new(sync.RWMutex)}
+	if err = tree.View(func(tx *bolt.Tx) error {
+		bpt.size = tx.Bucket(indexBucketName).Stats().KeyN
+		return nil
+	}); err != nil {
+		_ = tree.Close()
+		return nil, err
+	}
+	return bpt, nil
+}
+
+func openBoltTree(fileName string) (*bolt.DB, error) {
+	tree, err := bolt.Open(fileName, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 写入时不做 fsync，由 SaveCheckpoint 统一持久化
+	tree.NoSync = true
+	if err = tree.Update(func(tx *bolt.Tx) error {
+		if _, err1 := tx.CreateBucketIfNotExists(indexBucketName); err1 != nil {
+			return err1
+		}
+		_, err1 := tx.CreateBucketIfNotExists(metaBucketName)
+		return err1
+	}); err != nil {
+		_ = tree.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Put 单独一个bolt事务写入一个key，写入失败时返回nil，错误由之后的 Update / SaveCheckpoint 返回
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var oldPos *data.LogRecordPos
+	bpt.setErr(bpt.Update(func(w Writer) error {
+		oldPos = w.Put(key, pos)
+		return nil
+	}, nil))
+	return oldPos
+}
+
+// Get 读事务只会在索引已经关闭时失败，此时返回nil
+// 总是在独立的读事务中读取，读不到正在执行的 Update 还没有提交的修改（它们只能通过 Update 的 Writer 读到）
+func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	var pos *data.LogRecordPos
+	_ = bpt.tree.View(func(tx *bolt.Tx) error {
+		pos = getPos(tx.Bucket(indexBucketName), key)
+		return nil
+	})
+	return pos
+}
+
+// Delete 单独一个bolt事务删除一个key，写入失败时返回nil，错误由之后的 Update / SaveCheckpoint 返回
+func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var oldPos *data.LogRecordPos
+	var ok bool
+	bpt.setErr(bpt.Update(func(w Writer) error {
+		oldPos, ok = w.Delete(key)
+		return nil
+	}, nil))
+	return oldPos, ok
+}
+
+// Update 在一个bolt事务中执行fn中所有的索引修改，checkpoint不为nil时在同一个事务中保存它返回的检查点
+// 任何一步失败时整个事务回滚并返回错误，索引和检查点都保持原样
+// bolt同一时刻只有一个写事务，fn中不能再调用 Update / Put / Delete，需要的读写都通过w完成
+func (bpt *BPlusTree) Update(fn func(w Writer) error, checkpoint func() *Checkpoint) error {
+	bpt.lock.RLock()
+	err := bpt.err
+	bpt.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var w *bptreeWriter
+	if err = bpt.tree.Update(func(tx *bolt.Tx) error {
+		w = &bptreeWriter{bucket: tx.Bucket(indexBucketName)}
+		if err1 := fn(w); err1 != nil {
+			return err1
+		}
+		if w.err != nil {
+			return w.err
+		}
+		if checkpoint == nil {
+			return nil
+		}
+		return tx.Bucket(metaBucketName).Put(checkpointKey, encodeCheckpoint(checkpoint()))
+	}); err != nil {
+		return err
+	}
+	bpt.lock.Lock()
+	bpt.size += w.sizeDelta
+	bpt.lock.Unlock()
+	return nil
+}
+
+func (bpt *BPlusTree) setErr(err error) {
+	if err == nil {
+		return
+	}
+	bpt.lock.Lock()
+	if bpt.err == nil {
+		bpt.err = err
+	}
+	bpt.lock.Unlock()
+}
+
+// bptreeWriter 在一个bolt写事务中修改索引，记录第一个错误，之后的修改都不再执行
+type bptreeWriter struct {
+	bucket    *bolt.Bucket
+	sizeDelta int
+	err       error
+}
+
+func (w *bptreeWriter) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	if w.err != nil {
+		return nil
+	}
+	oldPos := getPos(w.bucket, key)
+	if w.err = w.bucket.Put(key, data.EncodeLogRecordPos(pos)); w.err != nil {
+		return nil
+	}
+	if oldPos == nil {
+		w.sizeDelta++
+	}
+	return oldPos
+}
+
+func (w *bptreeWriter) Get(key []byte) *data.LogRecordPos {
+	return getPos(w.bucket, key)
+}
+
+func (w *bptreeWriter) Delete(key []byte) (*data.LogRecordPos, bool) {
+	if w.err != nil {
+		return nil, false
+	}
+	oldPos := getPos(w.bucket, key)
+	if oldPos == nil {
+		return nil, false
+	}
+	if w.err = w.bucket.Delete(key); w.err != nil {
+		return nil, false
+	}
+	w.sizeDelta--
+	return oldPos, true
+}
+
+func getPos(bucket *bolt.Bucket, key []byte) *data.LogRecordPos {
+	if value := bucket.Get(key); value != nil {
+		return data.DecodeLogRecordPos(value)
+	}
+	return nil
+}
+
+func (bpt *BPlusTree) Size() int {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	return bpt.size
+}
+
+func (bpt *BPlusTree) Close() error {
+	return bpt.tree.Close()
+}
+
+// Iterator 建立索引迭代器
+// 每一批数据在一个独立的bolt读事务中取出，不会长时间持有读事务，一致性说明见 cursorIterator
+func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
+	tree := bpt.tree
+	return newCursorIterator(func(start []byte, inclusive bool, n int, buf []*Item) []*Item {
+		_ = tree.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(indexBucketName).Cursor()
+			var key, value []byte
+			switch {
+			case start == nil && reverse:
+				key, value = cursor.Last()
+			case start == nil:
+				key, value = cursor.First()
+			default:
+				key, value = cursor.Seek(start)
+				// 反向遍历：从第一个小于等于start的key开始
+				if reverse && (key == nil || bytes.Compare(key, start) > 0) {
+					if key == nil {
+						key, value = cursor.Last()
+					} else {
+						key, value = cursor.Prev()
+					}
+				}
+				if !inclusive && key != nil && bytes.Equal(key, start) {
+					key, value = moveCursor(cursor, reverse)
+				}
+			}
+
+			for ; key != nil && len(buf) < n; key, value = moveCursor(cursor, reverse) {
+				// bolt返回的数据只在事务内有效，需要拷贝出来
+				buf = append(buf, &Item{
+					key: append([]byte(nil), key...),
+					pos: data.DecodeLogRecordPos(value),
+				})
+			}
+			return nil
+		})
+		return buf
+	})
+}
+
+func moveCursor(cursor *bolt.Cursor, reverse bool) ([]byte, []byte) {
+	if reverse {
+		return cursor.Prev()
+	}
+	return cursor.Next()
+}
+
+// LoadCheckpoint 读取上次保存的检查点，没有检查点时返回nil
+// 检查点读出后立即删除：打开数据库时重放检查点之后的记录不会保存检查点，中途崩溃的话下次打开需要重建索引
+func (bpt *BPlusTree) LoadCheckpoint() (*Checkpoint, error) {
+	var cp *Checkpoint
+	err := bpt.tree.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metaBucketName)
+		buf := bucket.Get(checkpointKey)
+		if buf == nil {
+			return nil
+		}
+		cp = decodeCheckpoint(buf)
+		return bucket.Delete(checkpointKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 删除检查点这件事必须先落盘
+	return cp, bpt.tree.Sync()
+}
+
+// SaveCheckpoint 持久化索引并保存检查点，在打开数据库加载完索引、以及正常关闭时调用
+func (bpt *BPlusTree) SaveCheckpoint(cp *Checkpoint) error {
+	if err := bpt.Update(func(w Writer) error { return nil }, func() *Checkpoint { return cp }); err != nil {
+		return err
+	}
+	return bpt.tree.Sync()
+}
+
+// Clear 清空索引中的所有数据，用于重建索引
+func (bpt *BPlusTree) Clear() error {
+	if err := bpt.tree.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(indexBucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(indexBucketName)
+		return err
+	}); err != nil {
+		return err
+	}
+	bpt.lock.Lock()
+	bpt.size = 0
+	bpt.lock.Unlock()
+	return nil
+}
+
+func encodeCheckpoint(cp *Checkpoint) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(cp.Fid))
+	index += binary.PutVarint(buf[index:], cp.Offset)
+	index += binary.PutUvarint(buf[index:], cp.SeqNo)
+	index += binary.PutVarint(buf[index:], cp.InvalidSize)
+	return append(buf[:index], EncodeFileInvalidSize(cp.FileInvalidSize)...)
+}
+
+func decodeCheckpoint(buf []byte) *Checkpoint {
+	var index = 0
+	fid, n := binary.Uvarint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	index += n
+	invalidSize, n := binary.Varint(buf[index:])
+	index += n
+	return &Checkpoint{
+		Fid:             uint32(fid),
+		Offset:          offset,
+		SeqNo:           seqNo,
+		InvalidSize:     invalidSize,
+		FileInvalidSize: DecodeFileInvalidSize(buf[index:]),
+	}
+}
+
+// EncodeFileInvalidSize 编码每个数据文件中的无效数据量：文件数量，之后依次是文件id和无效数据量
+func EncodeFileInvalidSize(sizes map[uint32]int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+len(sizes)*(binary.MaxVarintLen32+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(len(sizes)))
+	for fid, size := range sizes {
+		index += binary.PutUvarint(buf[index:], uint64(fid))
+		index += binary.PutVarint(buf[index:], size)
+	}
+	return buf[:index]
+}
+
+// DecodeFileInvalidSize 解码每个数据文件中的无效数据量，buf为空时（旧版本的检查点）返回空的map
+func DecodeFileInvalidSize(buf []byte) map[uint32]int64 {
+	sizes := make(map[uint32]int64)
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return sizes
+	}
+	var index = n
+	for i := uint64(0); i < count; i++ {
+		fid, n1 := binary.Uvarint(buf[index:])
+		if n1 <= 0 {
+			break
+		}
+		index += n1
+		size, n2 := binary.Varint(buf[index:])
+		if n2 <= 0 {
+			break
+		}
+		index += n2
+		sizes[uint32(fid)] = size
+	}
+	return sizes
+}
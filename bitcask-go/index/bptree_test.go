@@ -0,0 +1,210 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBPlusTree_Put(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-put")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer os.RemoveAll(path)
+	tree, err := NewBPlusTree(path)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	res1 := tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	assert.Nil(t, res1)
+	res2 := tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	assert.Nil(t, res2)
+	res3 := tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Equal(t, uint32(123), res3.Fid)
+	assert.Equal(t, int64(999), res3.Offset)
+	assert.Equal(t, 2, tree.Size())
+}
+
+func TestBPlusTree_Get(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-get")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer os.RemoveAll(path)
+	tree, err := NewBPlusTree(path)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	pos := tree.Get([]byte("not exist"))
+	assert.Nil(t, pos)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999, Size: 10})
+	pos1 := tree.Get([]byte("aac"))
+	assert.Equal(t, uint32(123), pos1.Fid)
+	assert.Equal(t, int64(999), pos1.Offset)
+	assert.Equal(t, uint32(10), pos1.Size)
+}
+
+func TestBPlusTree_Delete(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-delete")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer os.RemoveAll(path)
+	tree, err := NewBPlusTree(path)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	res1, ok1 := tree.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	res2, ok2 := tree.Delete([]byte("aac"))
+	assert.True(t, ok2)
+	assert.Equal(t, uint32(123), res2.Fid)
+	assert.Nil(t, tree.Get([]byte("aac")))
+	assert.Equal(t, 0, tree.Size())
+}
+
+func TestBPlusTree_Iterator(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-iter")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer os.RemoveAll(path)
+	tree, err := NewBPlusTree(path)
+	assert.Nil(t, err)
+	defer tree.Close()
+
+	// 1.为空的情况
+	iter1 := tree.Iterator(false)
+	assert.Equal(t, false, iter1.IsValid())
+
+	// 2.有多条数据
+	tree.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	var keys []string
+	iter2 := tree.Iterator(false)
+	for iter2.Rewind(); iter2.IsValid(); iter2.Next() {
+		keys = append(keys, string(iter2.Key()))
+		assert.NotNil(t, iter2.Value())
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eede"}, keys)
+
+	// 3.seek
+	iter2.Seek([]byte("cc"))
+	assert.Equal(t, []byte("ccde"), iter2.Key())
+
+	// 4.反向遍历的 seek
+	iter3 := tree.Iterator(true)
+	iter3.Seek([]byte("zz"))
+	assert.Equal(t, []byte("eede"), iter3.Key())
+	iter3.Seek([]byte("cc"))
+	assert.Equal(t, []byte("bbcd"), iter3.Key())
+	iter3.Next()
+	assert.Equal(t, []byte("acee"), iter3.Key())
+	iter3.Next()
+	assert.Equal(t, false, iter3.IsValid())
+}
+
+func TestBPlusTree_Checkpoint(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-checkpoint")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer os.RemoveAll(path)
+
+	tree, err := NewBPlusTree(path)
+	assert.Nil(t, err)
+	cp, err := tree.LoadCheckpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, cp)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	err = tree.SaveCheckpoint(&Checkpoint{Fid: 3, Offset: 100, SeqNo: 7, InvalidSize: 20,
+		FileInvalidSize: map[uint32]int64{1: 15, 3: 5}})
+	assert.Nil(t, err)
+	_ = tree.Close()
+
+	// 重新打开后数据和检查点都还在，检查点读出一次后就被删除
+	tree, err = NewBPlusTree(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, tree.Size())
+	cp, err = tree.LoadCheckpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, &Checkpoint{Fid: 3, Offset: 100, SeqNo: 7, InvalidSize: 20,
+		FileInvalidSize: map[uint32]int64{1: 15, 3: 5}}, cp)
+	cp, err = tree.LoadCheckpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, cp)
+
+	err = tree.Clear()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tree.Size())
+	assert.Nil(t, tree.Get([]byte("aac")))
+	_ = tree.Close()
+}
+
+func TestBPlusTree_Update(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-update")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer os.RemoveAll(path)
+	tree, err := NewBPlusTree(path)
+	assert.Nil(t, err)
+	defer tree.Close()
+	cp := &Checkpoint{Fid: 1, Offset: 10, FileInvalidSize: map[uint32]int64{}}
+
+	// 1.一次Update中的修改和检查点一起生效
+	err = tree.Update(func(w Writer) error {
+		assert.Nil(t, w.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 0}))
+		assert.Nil(t, w.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 5}))
+		_, ok := w.Delete([]byte("b"))
+		assert.True(t, ok)
+		return nil
+	}, func() *Checkpoint { return cp })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, tree.Size())
+	assert.NotNil(t, tree.Get([]byte("a")))
+
+	// 2.失败时整个事务回滚，索引和检查点都保持原样
+	err = tree.Update(func(w Writer) error {
+		w.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 0})
+		w.Delete([]byte("a"))
+		return errors.New("failed")
+	}, func() *Checkpoint { return &Checkpoint{Fid: 2, Offset: 20} })
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, tree.Size())
+	assert.NotNil(t, tree.Get([]byte("a")))
+	assert.Nil(t, tree.Get([]byte("c")))
+	loaded, err := tree.LoadCheckpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, cp, loaded)
+
+	// 3.bolt写入失败时返回错误而不是panic
+	err = tree.Update(func(w Writer) error {
+		w.Put(nil, &data.LogRecordPos{Fid: 2, Offset: 0})
+		return nil
+	}, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, tree.Size())
+
+	// 4.还没有提交的修改只能通过Writer读到，其他的Get在读事务中读取，读到的是提交前的数据
+	err = tree.Update(func(w Writer) error {
+		assert.Nil(t, w.Put([]byte("d"), &data.LogRecordPos{Fid: 3, Offset: 0}))
+		_, ok := w.Delete([]byte("a"))
+		assert.True(t, ok)
+		assert.NotNil(t, w.Get([]byte("d")))
+		assert.Nil(t, w.Get([]byte("a")))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.Nil(t, tree.Get([]byte("d")))
+			assert.NotNil(t, tree.Get([]byte("a")))
+		}()
+		<-done
+		return errors.New("failed")
+	}, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, tree.Size())
+	assert.NotNil(t, tree.Get([]byte("a")))
+	assert.Nil(t, tree.Get([]byte("d")))
+
+	_, err = NewBPlusTree(filepath.Join(path, "not-exist"))
+	assert.NotNil(t, err)
+}
@@ -4,7 +4,6 @@ import (
 	"bitcask-go/data"
 	"bytes"
 	"github.com/google/btree"
-	"sort"
 	"sync"
 )
 
@@ -40,12 +39,12 @@ func (bt *BTreeIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 	return oldItem.(*Item).pos
 }
 
-// Get 取数据, 取数据需要加锁吗？——————> 不需要！因为google的btree库读是安全的！！！*****
+// Get 取数据。google的btree库只有在没有并发写时读才是安全的，merge等操作会在不持有db锁的情况下读索引，所以需要加读锁
 func (bt *BTreeIndex) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
-	// bt.lock.Lock()
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
-	// bt.lock.Unlock()
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -70,88 +69,49 @@ func (bt *BTreeIndex) Close() error {
 	return nil
 }
 
+// Clone 复制一份索引。google btree 的 Clone 是写时复制的，代价与索引大小无关
+// Clone 过程中会修改原树的内部状态，所以需要加写锁
+func (bt *BTreeIndex) Clone() Indexer {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTreeIndex{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
 // Iterator 建立内存索引迭代器
+// 迭代器按批次从b树中取数据，不会拷贝整个索引，一致性说明见 cursorIterator
 func (bt *BTreeIndex) Iterator(reverse bool) Iterator {
 	if bt == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
-}
-
-// BTreeIndex 索引迭代器（不面向用户）
-// 问题：要将items都保存在内存中的values数组里，可能导致内存不够用！！！！
-// 但是没办法，因为google btree自带的迭代器不能满足需求。
-type btreeIterator struct {
-	currIndex int     // 当前位置指针：当前遍历到values的下标位置
-	reverse   bool    // 是否是反向遍历
-	values    []*Item // 从内存取出的 key+位置索引 信息
-}
-
-func newBTreeIterator(t *btree.BTree, reverse bool) *btreeIterator {
-	var i int
-	values := make([]*Item, t.Len())
-
-	// 定义一个方法，用于在遍历中保存b树上的所有item
-	saveItems := func(bi btree.Item) bool {
-		values[i] = bi.(*Item)
-		i++
-		return true // 如果返回FALSE就会终止BTree的遍历
-	}
-
-	if reverse {
-		// 反向遍历b树，接受一个遍历时的处理方法，将b树中的items保存下来
-		t.Descend(saveItems)
-	} else {
-		// 正向遍历b树，接受一个遍历时的处理方法
-		t.Ascend(saveItems)
-	}
-
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
-	}
-
-}
-
-func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0 // currIndex设置为0，即回到起点
-}
-
-func (bti *btreeIterator) Seek(key []byte) {
-	// values本身就是有序的
-	// 二分查找
-	if bti.reverse {
-		// currIndex设置为找到第一个小于等于key的item的下标
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0 // 找到第一个小于等于key的
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0 // 找到第一个大于等于key的
-		})
-	}
-}
-
-func (bti *btreeIterator) Next() {
-	bti.currIndex += 1
-}
-
-func (bti *btreeIterator) IsValid() bool {
-	return bti.currIndex < len(bti.values)
-}
-
-func (bti *btreeIterator) Key() []byte {
-	return bti.values[bti.currIndex].key
-}
-
-func (bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.currIndex].pos
-}
-
-func (bti *btreeIterator) Close() {
-	// 清理掉临时数组
-	bti.values = nil
+	// 记下当前的树和锁，索引被Close后迭代器仍然可以安全地访问
+	tree, lock := bt.tree, bt.lock
+	return newCursorIterator(func(start []byte, inclusive bool, n int, buf []*Item) []*Item {
+		saveItems := func(bi btree.Item) bool {
+			item := bi.(*Item)
+			if !inclusive && bytes.Equal(item.key, start) {
+				return true // 跳过上一批的最后一个key
+			}
+			buf = append(buf, item)
+			return len(buf) < n // 如果返回FALSE就会终止BTree的遍历
+		}
+
+		lock.RLock()
+		defer lock.RUnlock()
+		switch {
+		case start == nil && reverse:
+			tree.Descend(saveItems)
+		case start == nil:
+			tree.Ascend(saveItems)
+		case reverse:
+			// 反向遍历：从第一个小于等于start的item开始
+			tree.DescendLessOrEqual(&Item{key: start}, saveItems)
+		default:
+			// 正向遍历：从第一个大于等于start的item开始
+			tree.AscendGreaterOrEqual(&Item{key: start}, saveItems)
+		}
+		return buf
+	})
 }
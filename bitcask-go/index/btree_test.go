@@ -99,3 +99,25 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Clone(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	// 复制之后两份索引互不影响
+	clone := Clone(bt)
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	bt.Delete([]byte("a"))
+	assert.Equal(t, 2, clone.Size())
+	assert.NotNil(t, clone.Get([]byte("a")))
+	assert.Nil(t, clone.Get([]byte("c")))
+
+	// 不支持写时复制的索引逐条拷贝
+	art := NewART()
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	clone2 := Clone(art)
+	art.Delete([]byte("a"))
+	assert.Equal(t, 1, clone2.Size())
+	assert.Equal(t, int64(10), clone2.Get([]byte("a")).Offset)
+}
@@ -0,0 +1,179 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+)
+
+// cursorBatchSize 游标迭代器每次从索引中取出的 item 数量
+const cursorBatchSize = 256
+
+// fetchFunc 从索引中按迭代方向取出从 start 开始的最多 n 个 item，追加到 buf 后返回
+// start 为 nil 表示从头开始；inclusive 为 false 时跳过等于 start 的 key
+// 实现方需要自己在取数据期间持有索引的读锁
+type fetchFunc func(start []byte, inclusive bool, n int, buf []*Item) []*Item
+
+// cursorIterator 游标式的索引迭代器（不面向用户）
+// 不再一次性把整个索引拷贝到 values 数组里，而是每次只取一小批，用完再取下一批，内存占用与索引大小无关
+//
+// 一致性：弱一致。每一批都在索引读锁下取出，保证 key 严格有序、不会重复出现；
+// 但两批之间索引可能被修改，迭代过程中新写入或删除的 key 可能被看到，也可能看不到。
+// 需要一致视图时请使用快照（Snapshot）。
+type cursorIterator struct {
+	fetch   fetchFunc
+	values  []*Item // 当前这一批 item
+	index   int     // 当前位置在 values 中的下标
+	lastKey []byte  // 当前批次的最后一个 key，用于定位下一批
+	drained bool    // 索引已经取完，不再有下一批
+}
+
+func newCursorIterator(fetch fetchFunc) *cursorIterator {
+	iter := &cursorIterator{
+		fetch:  fetch,
+		values: make([]*Item, 0, cursorBatchSize),
+	}
+	iter.Rewind()
+	return iter
+}
+
+// load 从 start 开始重新取一批数据
+func (ci *cursorIterator) load(start []byte, inclusive bool) {
+	ci.values = ci.fetch(start, inclusive, cursorBatchSize, ci.values[:0])
+	ci.index = 0
+	ci.drained = len(ci.values) < cursorBatchSize
+	if len(ci.values) > 0 {
+		ci.lastKey = ci.values[len(ci.values)-1].key
+	}
+}
+
+func (ci *cursorIterator) Rewind() {
+	ci.load(nil, true)
+}
+
+func (ci *cursorIterator) Seek(key []byte) {
+	ci.load(key, true)
+}
+
+func (ci *cursorIterator) Next() {
+	ci.index++
+	if ci.index >= len(ci.values) && !ci.drained {
+		ci.load(ci.lastKey, false)
+	}
+}
+
+func (ci *cursorIterator) IsValid() bool {
+	return ci.index < len(ci.values)
+}
+
+func (ci *cursorIterator) Key() []byte {
+	return ci.values[ci.index].key
+}
+
+func (ci *cursorIterator) Value() *data.LogRecordPos {
+	return ci.values[ci.index].pos
+}
+
+func (ci *cursorIterator) Close() {
+	ci.values = nil
+	ci.lastKey = nil
+	ci.drained = true
+}
+
+// scanFunc 按从小到大的顺序遍历所有以 prefix 开头的 key，fn 返回 false 时停止
+// 用于没有办法直接从某个 key 开始遍历（或者反向遍历）的索引，实现方需要自己在遍历期间持有索引的读锁
+type scanFunc func(prefix []byte, fn func(key []byte, pos *data.LogRecordPos) bool)
+
+// fetchByPrefix 用 scan 正向取出从 start 开始的最多 n 个 item，追加到 buf 后返回
+// 大于等于 start 的 key 按顺序分为：以 start 开头的 key，以及对每个 i（从后往前）以 start[:i] 开头、下一个字节大于 start[i] 的 key，
+// 每一部分都是一次前缀遍历，不需要从头跳过小于 start 的 key；最坏情况下要做 256 × len(start) 次前缀遍历
+func fetchByPrefix(scan scanFunc, start []byte, inclusive bool, n int, buf []*Item) []*Item {
+	add := func(key []byte, pos *data.LogRecordPos) bool {
+		if inclusive || !bytes.Equal(key, start) {
+			buf = append(buf, &Item{key: key, pos: pos})
+		}
+		return len(buf) < n
+	}
+	scan(start, add)
+	for i := len(start) - 1; i >= 0 && len(buf) < n; i-- {
+		for b := int(start[i]) + 1; b <= 0xff && len(buf) < n; b++ {
+			scan(childPrefix(start[:i], byte(b)), add)
+		}
+	}
+	return buf
+}
+
+// fetchReverseByPrefix 用 scan 反向取出从 start 开始（start为nil时从最大的key开始）的最多 n 个 item，追加到 buf 后返回
+// 按前缀逐个字节从大到小拆分：key不多的子树整体取出后倒序追加，否则继续按下一个字节拆分，只会读取这一批附近的 key；
+// 每一层最多试探 256 个字节，一批最多要做 256 × key长度 次前缀遍历，代价随 key 长度增长
+func fetchReverseByPrefix(scan scanFunc, start []byte, inclusive bool, n int, buf []*Item) []*Item {
+	r := &reverseFetcher{scan: scan, n: n, buf: buf}
+	if !inclusive {
+		r.skip = start
+	}
+	r.descend(nil, start)
+	return r.buf
+}
+
+type reverseFetcher struct {
+	scan    scanFunc
+	n       int
+	buf     []*Item
+	skip    []byte // 不包含起点时需要跳过的 key
+	scratch []Item // 试探子树大小时暂存取出的 item，反复使用
+}
+
+// descend 从大到小取出以 prefix 开头的 key；bound 不为nil时只取小于等于 bound 的 key（bound 以 prefix 开头）
+func (r *reverseFetcher) descend(prefix, bound []byte) {
+	hi := 0xff
+	if bound != nil {
+		if len(bound) == len(prefix) {
+			r.addExact(prefix)
+			return
+		}
+		hi = int(bound[len(prefix)])
+	}
+	for b := hi; b >= 0 && len(r.buf) < r.n; b-- {
+		child := childPrefix(prefix, byte(b))
+		if bound != nil && b == hi {
+			r.descend(child, bound)
+			continue
+		}
+		limit := r.n - len(r.buf) + 1
+		items := r.scratch[:0]
+		r.scan(child, func(key []byte, pos *data.LogRecordPos) bool {
+			items = append(items, Item{key: key, pos: pos})
+			return len(items) < limit
+		})
+		r.scratch = items
+		if len(items) < limit {
+			for i := len(items) - 1; i >= 0; i-- {
+				r.buf = append(r.buf, &Item{key: items[i].key, pos: items[i].pos})
+			}
+		} else {
+			r.descend(child, nil)
+		}
+	}
+	if len(r.buf) < r.n {
+		r.addExact(prefix)
+	}
+}
+
+// addExact 前缀本身也是一个 key 时取出它，它比所有以它开头的其他 key 都小
+func (r *reverseFetcher) addExact(prefix []byte) {
+	if len(prefix) == 0 || bytes.Equal(prefix, r.skip) {
+		return
+	}
+	r.scan(prefix, func(key []byte, pos *data.LogRecordPos) bool {
+		if bytes.Equal(key, prefix) {
+			r.buf = append(r.buf, &Item{key: key, pos: pos})
+		}
+		return false
+	})
+}
+
+func childPrefix(prefix []byte, b byte) []byte {
+	child := make([]byte, len(prefix)+1)
+	copy(child, prefix)
+	child[len(prefix)] = b
+	return child
+}
@@ -0,0 +1,153 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestCursorIterator_Batches(t *testing.T) {
+	n := cursorBatchSize*3 + 10
+	for _, tp := range []IndexType{Btree, ART, SkipList} {
+		idx, _ := NewIndexer(tp, "")
+		for i := 0; i < n; i++ {
+			idx.Put([]byte(fmt.Sprintf("key-%06d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		// 1.正向遍历，跨越多个批次
+		iter := idx.Iterator(false)
+		count := 0
+		for iter.Rewind(); iter.IsValid(); iter.Next() {
+			assert.Equal(t, []byte(fmt.Sprintf("key-%06d", count)), iter.Key())
+			assert.Equal(t, int64(count), iter.Value().Offset)
+			count++
+		}
+		assert.Equal(t, n, count)
+
+		// 2.seek 到中间
+		iter.Seek([]byte("key-000300"))
+		assert.True(t, iter.IsValid())
+		assert.Equal(t, []byte("key-000300"), iter.Key())
+		iter.Seek([]byte("key-0003000"))
+		assert.Equal(t, []byte("key-000301"), iter.Key())
+		iter.Seek([]byte("zzz"))
+		assert.False(t, iter.IsValid())
+		iter.Close()
+
+		// 3.反向遍历
+		iter = idx.Iterator(true)
+		count = 0
+		for iter.Seek([]byte("key-000500")); iter.IsValid(); iter.Next() {
+			assert.Equal(t, []byte(fmt.Sprintf("key-%06d", 500-count)), iter.Key())
+			count++
+		}
+		assert.Equal(t, 501, count)
+		iter.Close()
+	}
+}
+
+func TestCursorIterator_ConcurrentModification(t *testing.T) {
+	n := cursorBatchSize * 2
+	for _, tp := range []IndexType{Btree, ART, SkipList} {
+		idx, _ := NewIndexer(tp, "")
+		for i := 0; i < n; i++ {
+			idx.Put([]byte(fmt.Sprintf("key-%06d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		// 遍历过程中修改索引：key 仍然严格有序、不重复，已删除的后续 key 不会再出现
+		iter := idx.Iterator(false)
+		var prev []byte
+		count := 0
+		for iter.Rewind(); iter.IsValid(); iter.Next() {
+			if prev != nil {
+				assert.Equal(t, -1, bytes.Compare(prev, iter.Key()))
+			}
+			prev = iter.Key()
+			if count == 10 {
+				idx.Delete([]byte(fmt.Sprintf("key-%06d", n-1)))
+				idx.Put([]byte("key-000000-new"), &data.LogRecordPos{Fid: 1})
+				idx.Put([]byte("zzz"), &data.LogRecordPos{Fid: 1})
+			}
+			assert.NotEqual(t, []byte(fmt.Sprintf("key-%06d", n-1)), iter.Key())
+			count++
+		}
+		assert.Equal(t, []byte("zzz"), prev)
+		iter.Close()
+	}
+}
+
+func TestCursorIterator_SeekByPrefix(t *testing.T) {
+	// 随机的key，包含互为前缀的key以及 0x00、0xff 字节
+	rnd := rand.New(rand.NewSource(1))
+	keySet := make(map[string]struct{})
+	for len(keySet) < 2000 {
+		key := make([]byte, 1+rnd.Intn(5))
+		for i := range key {
+			key[i] = []byte{0x00, 0x01, 'a', 'b', 0xfe, 0xff}[rnd.Intn(6)]
+		}
+		keySet[string(key)] = struct{}{}
+	}
+	var keys []string
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, tp := range []IndexType{ART, SkipList} {
+		idx, _ := NewIndexer(tp, "")
+		for i, key := range keys {
+			idx.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		for _, reverse := range []bool{false, true} {
+			iter := idx.Iterator(reverse)
+			// 1.完整遍历
+			var got []string
+			for iter.Rewind(); iter.IsValid(); iter.Next() {
+				got = append(got, string(iter.Key()))
+			}
+			assert.Equal(t, len(keys), len(got))
+			for i := range got {
+				if reverse {
+					assert.Equal(t, keys[len(keys)-1-i], got[i])
+				} else {
+					assert.Equal(t, keys[i], got[i])
+				}
+			}
+
+			// 2.从随机位置seek之后取若干个key，与有序数组比较
+			for j := 0; j < 200; j++ {
+				target := make([]byte, rnd.Intn(5))
+				for i := range target {
+					target[i] = byte(rnd.Intn(256))
+				}
+				if j%2 == 0 {
+					target = []byte(keys[rnd.Intn(len(keys))])
+				}
+				expected := []string{}
+				if reverse {
+					i := sort.SearchStrings(keys, string(target))
+					if i < len(keys) && keys[i] == string(target) {
+						i++
+					}
+					for k := i - 1; k >= 0 && len(expected) < cursorBatchSize+10; k-- {
+						expected = append(expected, keys[k])
+					}
+				} else {
+					for k := sort.SearchStrings(keys, string(target)); k < len(keys) && len(expected) < cursorBatchSize+10; k++ {
+						expected = append(expected, keys[k])
+					}
+				}
+				got = got[:0]
+				for iter.Seek(target); iter.IsValid() && len(got) < cursorBatchSize+10; iter.Next() {
+					got = append(got, string(iter.Key()))
+				}
+				assert.Equal(t, expected, got, "seek %x reverse %v", target, reverse)
+			}
+			iter.Close()
+		}
+	}
+}
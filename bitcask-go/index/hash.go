@@ -2,6 +2,8 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"sort"
 	"sync"
 )
 
@@ -67,48 +69,74 @@ func (h *SafeHashTable) Close() error {
 	return nil
 }
 
+// Iterator 建立哈希索引迭代器
+// 哈希表本身是无序的，所以需要把所有 key 拷贝出来排好序，Seek、反向遍历的语义与BTree索引一致
+// 迭代器是建立时刻索引的一份快照，之后对索引的修改不会体现在迭代器中
 func (h *SafeHashTable) Iterator(reverse bool) Iterator {
-	//TODO implement me
-	panic("not implemented")
+	h.lock.RLock()
+	values := make([]*Item, 0, len(h.hash))
+	for key, pos := range h.hash {
+		values = append(values, &Item{key: []byte(key), pos: pos})
+	}
+	h.lock.RUnlock()
+
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+
+	return &hashIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
 }
 
+// hashIterator 哈希索引迭代器（不面向用户）
 type hashIterator struct {
 	currIndex int     // 当前位置指针：当前遍历到values的下标位置
 	reverse   bool    // 是否是反向遍历
-	values    []*Item // 从内存取出的 key+位置索引 信息
+	values    []*Item // 从内存取出的 key+位置索引 信息，已按 key 排好序
 }
 
-func (h hashIterator) Rewind() {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) Rewind() {
+	h.currIndex = 0
 }
 
-func (h hashIterator) Seek(key []byte) {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) Seek(key []byte) {
+	// 二分查找
+	if h.reverse {
+		// 找到第一个小于等于key的
+		h.currIndex = sort.Search(len(h.values), func(i int) bool {
+			return bytes.Compare(h.values[i].key, key) <= 0
+		})
+	} else {
+		// 找到第一个大于等于key的
+		h.currIndex = sort.Search(len(h.values), func(i int) bool {
+			return bytes.Compare(h.values[i].key, key) >= 0
+		})
+	}
 }
 
-func (h hashIterator) Next() {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) Next() {
+	h.currIndex += 1
 }
 
-func (h hashIterator) IsValid() bool {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) IsValid() bool {
+	return h.currIndex < len(h.values)
 }
 
-func (h hashIterator) Key() []byte {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) Key() []byte {
+	return h.values[h.currIndex].key
 }
 
-func (h hashIterator) Value() *data.LogRecordPos {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) Value() *data.LogRecordPos {
+	return h.values[h.currIndex].pos
 }
 
-func (h hashIterator) Close() {
-	//TODO implement me
-	panic("not implemented")
+func (h *hashIterator) Close() {
+	// 清理掉临时数组
+	h.values = nil
 }
@@ -60,3 +60,53 @@ func TestNewSafeHashTable_Size(t *testing.T) {
 	hash.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
 	assert.Equal(t, 2, hash.Size())
 }
+
+func TestNewSafeHashTable_Iterator(t *testing.T) {
+	hash := NewSafeHashTable()
+	// 1.哈希表为空的情况
+	iter1 := hash.Iterator(false)
+	assert.Equal(t, false, iter1.IsValid())
+
+	//	2.哈希表有数据的情况
+	hash.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	iter2 := hash.Iterator(false)
+	assert.Equal(t, true, iter2.IsValid())
+	assert.Equal(t, []byte("ccde"), iter2.Key())
+	assert.NotNil(t, iter2.Value())
+	iter2.Next()
+	assert.Equal(t, false, iter2.IsValid())
+
+	// 3.有多条数据，按 key 有序遍历
+	hash.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	hash.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	hash.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	var keys []string
+	iter3 := hash.Iterator(false)
+	for iter3.Rewind(); iter3.IsValid(); iter3.Next() {
+		keys = append(keys, string(iter3.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eede"}, keys)
+
+	keys = nil
+	iter4 := hash.Iterator(true)
+	for iter4.Rewind(); iter4.IsValid(); iter4.Next() {
+		keys = append(keys, string(iter4.Key()))
+	}
+	assert.Equal(t, []string{"eede", "ccde", "bbcd", "acee"}, keys)
+
+	// 4.测试 seek
+	iter5 := hash.Iterator(false)
+	iter5.Seek([]byte("cc"))
+	assert.Equal(t, []byte("ccde"), iter5.Key())
+	iter5.Seek([]byte("zz"))
+	assert.Equal(t, false, iter5.IsValid())
+
+	// 5.反向遍历的 seek
+	iter6 := hash.Iterator(true)
+	iter6.Seek([]byte("zz"))
+	assert.Equal(t, []byte("eede"), iter6.Key())
+	iter6.Seek([]byte("cc"))
+	assert.Equal(t, []byte("bbcd"), iter6.Key())
+	iter6.Close()
+	assert.Equal(t, false, iter6.IsValid())
+}
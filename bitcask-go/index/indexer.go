@@ -37,22 +37,28 @@ const (
 
 	// SkipList B+ 树索引
 	SkipList
+
+	// BPTree 磁盘上的B+树索引
+	BPTree
 )
 
-// NewIndexer 根据类型，初始化索引
-func NewIndexer(t IndexType) Indexer {
+// NewIndexer 根据类型，初始化索引。dirPath 是数据目录，只有磁盘索引会用到（也只有它会返回错误）
+func NewIndexer(t IndexType, dirPath string) (Indexer, error) {
 	switch t {
 	case Btree:
-		return NewBTree()
+		return NewBTree(), nil
 
 	case Hash:
-		return NewSafeHashTable()
+		return NewSafeHashTable(), nil
 
 	case ART:
-		return NewART()
+		return NewART(), nil
 
 	case SkipList:
-		return NewSkipList()
+		return NewSkipList(), nil
+
+	case BPTree:
+		return NewBPlusTree(dirPath)
 
 	default:
 		panic("unsupported index type")
@@ -60,6 +66,49 @@ func NewIndexer(t IndexType) Indexer {
 	}
 }
 
+// Writer 索引的修改操作，Indexer都实现了它
+type Writer interface {
+	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
+	Get(key []byte) *data.LogRecordPos
+	Delete(key []byte) (*data.LogRecordPos, bool)
+}
+
+// Persistent 持久化在磁盘上的索引，重新打开数据库时不需要从数据文件重建
+type Persistent interface {
+	Indexer
+	// Update 在一个事务中执行fn中所有的索引修改，并保存checkpoint返回的检查点（为nil时不保存）
+	// 写入失败时整个事务回滚并返回错误。还没有提交的修改只能通过w读到，不能在fn中再调用Update
+	Update(fn func(w Writer) error, checkpoint func() *Checkpoint) error
+	// LoadCheckpoint 读取上次保存的检查点，没有时返回nil
+	LoadCheckpoint() (*Checkpoint, error)
+	// SaveCheckpoint 持久化索引并保存检查点
+	SaveCheckpoint(cp *Checkpoint) error
+	// Clear 清空索引
+	Clear() error
+}
+
+// cloner 可以高效复制自身的索引（例如支持写时复制的BTree）
+type cloner interface {
+	Clone() Indexer
+}
+
+// Clone 复制一份与原索引互不影响的只读副本，用于快照
+// 索引本身支持写时复制时（BTree）直接使用，代价与索引大小无关；
+// 否则（ART、SkipList、Hash）逐条拷贝到一个新的BTree中，时间和内存都与key的数量成正比。
+// 磁盘索引（BPlusTree）会把整个索引读进内存，不应该使用
+func Clone(idx Indexer) Indexer {
+	if c, ok := idx.(cloner); ok {
+		return c.Clone()
+	}
+	bt := NewBTree()
+	iter := idx.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.IsValid(); iter.Next() {
+		bt.Put(iter.Key(), iter.Value())
+	}
+	return bt
+}
+
 // Iterator 索引迭代器(内部使用，不面向用户)
 type Iterator interface {
 	// Rewind 重新回到迭代器的起点，即第一个数据
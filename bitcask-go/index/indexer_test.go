@@ -0,0 +1,53 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	for _, tp := range []IndexType{Btree, ART, SkipList, Hash} {
+		idx, _ := NewIndexer(tp, "")
+		for i := 0; i < 100; i++ {
+			idx.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		// 复制之后两边的修改互不影响
+		clone := Clone(idx)
+		idx.Put([]byte("key-000"), &data.LogRecordPos{Fid: 2, Offset: 0})
+		idx.Delete([]byte("key-001"))
+		clone.Put([]byte("key-100"), &data.LogRecordPos{Fid: 1, Offset: 100})
+		assert.Equal(t, 101, clone.Size())
+		assert.Equal(t, uint32(1), clone.Get([]byte("key-000")).Fid)
+		assert.NotNil(t, clone.Get([]byte("key-001")))
+		assert.Equal(t, 99, idx.Size())
+		assert.Nil(t, idx.Get([]byte("key-100")))
+	}
+
+	// BTree写时复制，复制时不会逐条拷贝
+	bt := NewBTree()
+	for i := 0; i < 10000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Less(t, testing.AllocsPerRun(10, func() { Clone(bt) }), float64(10))
+}
+
+// BenchmarkClone 创建快照的代价：BTree是写时复制的，与key的数量无关；其他索引需要逐条复制
+func BenchmarkClone(b *testing.B) {
+	for _, tp := range []IndexType{Btree, ART, SkipList, Hash} {
+		for _, n := range []int{1000, 100000} {
+			idx, _ := NewIndexer(tp, "")
+			for i := 0; i < n; i++ {
+				idx.Put([]byte(fmt.Sprintf("key-%09d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			}
+			b.Run(fmt.Sprintf("index-%d/keys-%d", tp, n), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					Clone(idx)
+				}
+			})
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bitcask-go/data"
 	"bytes"
 	"github.com/chen3feng/stl4go"
-	"sort"
 	"sync"
 )
 
@@ -80,93 +79,47 @@ func (sl *MySkipList) Close() error {
 // Iterator Gets the iterator of the SkipList index
 // If the reverse is true, the iterator is traversed in reverse order,
 // otherwise it is traversed in order
+//
+// Both directions walk the skip list in batches and do not copy the whole
+// index (see cursorIterator for their consistency guarantees). The skip list
+// is singly linked, so the reverse iterator splits the keys by prefix and
+// reads them one range at a time (see fetchReverseByPrefix). Each batch may
+// take up to 256 prefix scans per key byte, so reverse iteration over long
+// keys is much slower than with the BTree index (see the *_LongKey
+// benchmarks); prefer the BTree index for that workload.
 func (sl *MySkipList) Iterator(reverse bool) Iterator {
-	sl.lock.RLock()
-	defer sl.lock.RUnlock()
-	return NewSkipListIterator(sl, reverse)
-}
-
-type SkipListIterator struct {
-	currIndex int
-	reverse   bool
-	values    []*Item
-}
-
-// NewSkipListIterator Initializes the SkipList index iterator
-func NewSkipListIterator(sl *MySkipList, reverse bool) *SkipListIterator {
-	// Estimate the expected slice capacity based on skip list size
-	expectedSize := sl.Size()
-
-	// Initialize with empty slice and expected capacity
-	values := make([]*Item, 0, expectedSize)
-
-	// for each operation
-	saveToValues := func(K []byte, V *data.LogRecordPos) {
-		item := &Item{
-			key: K,
-			pos: V,
-		}
-		values = append(values, item)
-	}
-	sl.list.ForEach(saveToValues)
-
-	// Reverse the values slice if reverse is true
+	list, lock := sl.list, sl.lock
 	if reverse {
-		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
-			values[i], values[j] = values[j], values[i]
+		scan := func(prefix []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+			for it := list.LowerBound(prefix); it.IsNotEnd() && bytes.HasPrefix(it.Key(), prefix); it.MoveToNext() {
+				if !fn(it.Key(), it.Value()) {
+					return
+				}
+			}
 		}
-	}
-
-	return &SkipListIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
-	}
-}
-
-// Rewind Resets the iterator to the beginning
-func (sl *SkipListIterator) Rewind() {
-	sl.currIndex = 0
-}
-
-// Seek Positions the iterator to the first key
-// that is greater or equal to the specified key
-func (sl *SkipListIterator) Seek(key []byte) {
-	// binary search
-	if sl.reverse {
-		sl.currIndex = sort.Search(len(sl.values), func(i int) bool {
-			return bytes.Compare(sl.values[i].key, key) <= 0
-		})
-	} else {
-		sl.currIndex = sort.Search(len(sl.values), func(i int) bool {
-			return bytes.Compare(sl.values[i].key, key) >= 0
+		return newCursorIterator(func(start []byte, inclusive bool, n int, buf []*Item) []*Item {
+			lock.RLock()
+			defer lock.RUnlock()
+			return fetchReverseByPrefix(scan, start, inclusive, n, buf)
 		})
 	}
-}
-
-// Next Positions the iterator to the next key
-// If the iterator is positioned at the last key,
-// the iterator is positioned to the start of the iterator
-func (sl *SkipListIterator) Next() {
-	sl.currIndex += 1
-}
 
-// Valid Determines whether the iterator is positioned at a valid key
-func (sl *SkipListIterator) IsValid() bool {
-	return sl.currIndex < len(sl.values)
-}
-
-// Key Gets the key at the current iterator position
-func (sl *SkipListIterator) Key() []byte {
-	return sl.values[sl.currIndex].key
-}
-
-// Value Gets the value at the current iterator position
-func (sl *SkipListIterator) Value() *data.LogRecordPos {
-	return sl.values[sl.currIndex].pos
-}
-
-// Close Closes the iterator
-func (sl *SkipListIterator) Close() {
-	sl.values = nil
+	return newCursorIterator(func(start []byte, inclusive bool, n int, buf []*Item) []*Item {
+		lock.RLock()
+		defer lock.RUnlock()
+
+		var it stl4go.MapIterator[[]byte, *data.LogRecordPos]
+		switch {
+		case start == nil:
+			it = list.Iterate()
+		case inclusive:
+			it = list.LowerBound(start) // first key >= start
+		default:
+			it = list.UpperBound(start) // first key > start
+		}
+		for ; it.IsNotEnd() && len(buf) < n; it.MoveToNext() {
+			buf = append(buf, &Item{key: it.Key(), pos: it.Value()})
+		}
+		return buf
+	})
 }
@@ -0,0 +1,186 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// 索引快照文件的文件名，Close时写入，Open时读出后删除
+const indexSnapshotFileName = "index-snapshot"
+
+// indexSnapshotHeaderKey 索引快照第一条记录的key
+var indexSnapshotHeaderKey = []byte("index-snapshot")
+
+// indexSnapshotHeader 索引快照的头部：快照对应的数据位置，以及快照中有多少个key
+type indexSnapshotHeader struct {
+	index.Checkpoint
+	Count uint64
+}
+
+func encodeIndexSnapshotHeader(h *indexSnapshotHeader) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*4)
+	var n = 0
+	n += binary.PutUvarint(buf[n:], uint64(h.Fid))
+	n += binary.PutVarint(buf[n:], h.Offset)
+	n += binary.PutUvarint(buf[n:], h.SeqNo)
+	n += binary.PutVarint(buf[n:], h.InvalidSize)
+	n += binary.PutUvarint(buf[n:], h.Count)
+	return append(buf[:n], index.EncodeFileInvalidSize(h.FileInvalidSize)...)
+}
+
+func decodeIndexSnapshotHeader(buf []byte) *indexSnapshotHeader {
+	h := new(indexSnapshotHeader)
+	fid, n := binary.Uvarint(buf)
+	h.Fid = uint32(fid)
+	buf = buf[n:]
+	h.Offset, n = binary.Varint(buf)
+	buf = buf[n:]
+	h.SeqNo, n = binary.Uvarint(buf)
+	buf = buf[n:]
+	h.InvalidSize, n = binary.Varint(buf)
+	buf = buf[n:]
+	h.Count, n = binary.Uvarint(buf)
+	buf = buf[n:]
+	h.FileInvalidSize = index.DecodeFileInvalidSize(buf)
+	return h
+}
+
+// writeIndexSnapshot 把整个内存索引连同seqNo、无效数据量写入索引快照文件
+// 每条记录都带有crc校验，先写临时文件再重命名
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) writeIndexSnapshot() error {
+	fileName := filepath.Join(db.options.DirPath, indexSnapshotFileName)
+	tmpName := fileName + ".tmp"
+	if err := os.Remove(tmpName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	snapshotFile, err := db.openFile(tmpName, 0, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = snapshotFile.Close()
+		_ = os.Remove(tmpName)
+	}()
+
+	header, _ := snapshotFile.EncodeLogRecord(&data.LogRecord{
+		Key: indexSnapshotHeaderKey,
+		Value: encodeIndexSnapshotHeader(&indexSnapshotHeader{
+			Checkpoint: index.Checkpoint{
+				Fid:         db.activeFile.Fid,
+				Offset:      db.activeFile.WriteOffset,
+				SeqNo:       db.seqNo,
+				InvalidSize: db.invalidSize,
+
+				FileInvalidSize: db.fileInvalidSize,
+			},
+			Count: uint64(db.index.Size()),
+		}),
+	})
+	if err = snapshotFile.Write(header); err != nil {
+		return err
+	}
+
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.IsValid(); iter.Next() {
+		if err = snapshotFile.WriteHintFile(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	if err = snapshotFile.SyncFile(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// loadIndexSnapshot 从索引快照中加载索引，返回快照对应的数据位置，之后只需要加载这个位置之后的记录
+// 快照不存在、损坏或者已经过时（例如这次打开时刚完成了merge）时返回nil，此时需要重建索引
+// 快照文件读出后立即删除，避免之后没有正常关闭时误用过时的快照
+func (db *DB) loadIndexSnapshot(merged bool) *index.Checkpoint {
+	fileName := filepath.Join(db.options.DirPath, indexSnapshotFileName)
+	if _, err := os.Stat(fileName); err != nil {
+		return nil
+	}
+	defer func() {
+		// 只读模式下不修改数据目录，写进程打开时会删掉它
+		if !db.options.ReadOnly {
+			_ = os.Remove(fileName)
+		}
+	}()
+	if !db.options.IndexSnapshot || merged {
+		return nil
+	}
+
+	idx, cp, err := db.readIndexSnapshot()
+	if err == nil && !db.isValidCheckpoint(cp) {
+		err = ErrIndexSnapshotStale
+	}
+	if err != nil {
+		log.Printf("ignore the index snapshot: %v", err)
+		if idx != nil {
+			_ = idx.Close()
+		}
+		return nil
+	}
+
+	_ = db.index.Close()
+	db.index = idx
+	return cp
+}
+
+// readIndexSnapshot 把索引快照读进一个新的索引中，全部校验通过后才会替换DB的索引
+func (db *DB) readIndexSnapshot() (index.Indexer, *index.Checkpoint, error) {
+	snapshotFile, err := db.openFile(filepath.Join(db.options.DirPath, indexSnapshotFileName), 0, fio.ReadOnlyFIO)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snapshotFile.Close()
+
+	record, offset, err := snapshotFile.ReadLogRecord(0)
+	if err == io.EOF || (err == nil && !bytes.Equal(record.Key, indexSnapshotHeaderKey)) {
+		return nil, nil, ErrIndexSnapshotCorrupted
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	header := decodeIndexSnapshotHeader(record.Value)
+
+	idx, err := index.NewIndexer(db.options.IndexType, db.options.DirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var count uint64
+	for {
+		record, size, err1 := snapshotFile.ReadLogRecord(offset)
+		if err1 == io.EOF {
+			break
+		}
+		if err1 != nil {
+			return idx, nil, err1
+		}
+		offset += size
+		count++
+		pos := data.DecodeLogRecordPos(record.Value)
+		// 快照之后才过期的key不再放进索引
+		if pos.IsExpired() {
+			header.InvalidSize += int64(pos.Size)
+			header.FileInvalidSize[pos.Fid] += int64(pos.Size)
+			continue
+		}
+		idx.Put(record.Key, pos)
+	}
+	// key的数量对不上，说明文件不完整
+	if count != header.Count {
+		return idx, nil, ErrIndexSnapshotCorrupted
+	}
+	return idx, &header.Checkpoint, nil
+}
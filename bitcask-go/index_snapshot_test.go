@@ -0,0 +1,86 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_IndexSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-index-snapshot"
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	writeHintTestData(t, db)
+	err = db.PutWithTTL(utils.GetTestKey(9999), utils.RandomValue(10), 50*time.Millisecond)
+	assert.Nil(t, err)
+	stat := db.Stat()
+	keys := db.ListKeys()
+	err = db.Close()
+	assert.Nil(t, err)
+
+	snapshotName := filepath.Join(opts.DirPath, indexSnapshotFileName)
+	_, err = os.Stat(snapshotName)
+	assert.Nil(t, err)
+
+	// 1.从索引快照加载，加载后快照文件被删除
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(snapshotName)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+	assert.Equal(t, keys, db.ListKeys())
+
+	// 快照之后追加的记录会被重放
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 2.快照之后才过期的key不会被加载
+	time.Sleep(60 * time.Millisecond)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	_, err = db.Get(utils.GetTestKey(600))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(9999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	keyNum := db.Stat().KeyNum
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 3.损坏或者不完整的快照被忽略，重建索引
+	for _, corrupt := range []func([]byte) []byte{
+		func(b []byte) []byte { b[len(b)/2] ^= 0xff; return b },
+		func(b []byte) []byte { return b[:len(b)-20] },
+	} {
+		snapshot, err := os.ReadFile(snapshotName)
+		assert.Nil(t, err)
+		err = os.WriteFile(snapshotName, corrupt(snapshot), 0644)
+		assert.Nil(t, err)
+
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, keyNum, db.Stat().KeyNum)
+		_, err = db.Get(utils.GetTestKey(600))
+		assert.Equal(t, ErrKeyNotFound, err)
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keyNum, db.Stat().KeyNum)
+}
@@ -10,39 +10,77 @@ type IteratorUI struct {
 	indexIter index.Iterator // 内存索引迭代器，用于取出key和logRecord记录的位置信息
 	db        *DB            // 拿到位置信息pos后，需要从db中找出实际的值来返回给用户
 	options   IteratorOptions
+
+	// 由LowerBound、UpperBound和Prefix合并得到的遍历范围 [lowerBound, upperBound)，nil表示不限制
+	lowerBound []byte
+	upperBound []byte
+	count      int  // 从Rewind或Seek开始已经遍历过的key的数量，用于Limit
+	live       bool // 是否在db的实时索引上遍历（而不是快照中冻结的索引）
 }
 
 // NewIterator 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *IteratorUI {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &IteratorUI{
+	it := db.newIterator(db.index, opts)
+	it.live = true
+	return it
+}
+
+// newIterator 在指定的索引上初始化迭代器（db的实时索引或快照中冻结的索引）
+func (db *DB) newIterator(idx index.Indexer, opts IteratorOptions) *IteratorUI {
+	indexIter := idx.Iterator(opts.Reverse)
+	it := &IteratorUI{
 		db:        db,
 		indexIter: indexIter,
 		options:   opts,
 	}
+	it.lowerBound, it.upperBound = opts.bounds()
+	it.Rewind()
+	return it
 }
 
 // Rewind 重新回到迭代器的起点，即第一个数据
+// 有范围限制时直接Seek到范围的起点，而不是从头开始逐个跳过
 func (it *IteratorUI) Rewind() {
-	it.indexIter.Rewind()
-	it.nextPrefix()
+	it.count = 0
+	if it.options.Reverse && it.upperBound != nil {
+		it.indexIter.Seek(it.upperBound)
+	} else if !it.options.Reverse && it.lowerBound != nil {
+		it.indexIter.Seek(it.lowerBound)
+	} else {
+		it.indexIter.Rewind()
+	}
+	it.skipToNext()
 }
 
 // Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+// key在遍历范围之外时，从范围的起点开始
 func (it *IteratorUI) Seek(key []byte) {
+	it.count = 0
+	if it.options.Reverse && it.upperBound != nil && bytes.Compare(key, it.upperBound) > 0 {
+		key = it.upperBound
+	} else if !it.options.Reverse && it.lowerBound != nil && bytes.Compare(key, it.lowerBound) < 0 {
+		key = it.lowerBound
+	}
 	it.indexIter.Seek(key)
-	it.nextPrefix()
+	it.skipToNext()
 }
 
 // Next 跳转到下一个 key
 func (it *IteratorUI) Next() {
 	it.indexIter.Next()
-	it.nextPrefix()
+	it.count++
+	it.skipToNext()
 }
 
-// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+// Valid 是否有效，即是否已经遍历完了所有的 key，或者超出了遍历范围、达到了Limit，用于退出遍历
 func (it *IteratorUI) Valid() bool {
-	return it.indexIter.IsValid()
+	if !it.indexIter.IsValid() {
+		return false
+	}
+	if it.options.Limit > 0 && it.count >= it.options.Limit {
+		return false
+	}
+	return !it.pastEnd(it.indexIter.Key())
 }
 
 // Key 当前遍历位置的 Key 数据
@@ -55,6 +93,13 @@ func (it *IteratorUI) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
+	// 迭代器取到位置信息之后，merge可能已经把这条记录搬到了新的文件中，需要重新查一次索引
+	// 快照引用的文件不会被merge替换，不需要重新查
+	if it.live {
+		if logRecordPos = it.db.index.Get(it.indexIter.Key()); logRecordPos == nil {
+			return nil, ErrKeyNotFound
+		}
+	}
 	return it.db.getValueByPosition(logRecordPos)
 }
 
@@ -63,17 +108,65 @@ func (it *IteratorUI) Close() {
 	it.indexIter.Close()
 }
 
-// 如果用户配置了查找的key的prefix，则跳过不包含该prefix的key
-func (it *IteratorUI) nextPrefix() {
-	preLen := len(it.options.Prefix)
-	if preLen == 0 {
-		return
-	}
-
+// 跳过已过期的key，以及反向遍历时等于上界的key（上界不包含在范围内）
+func (it *IteratorUI) skipToNext() {
 	for ; it.indexIter.IsValid(); it.indexIter.Next() {
 		key := it.indexIter.Key()
-		if preLen <= len(key) && bytes.Compare(it.options.Prefix, key[:preLen]) == 0 {
-			break
+		if it.pastEnd(key) {
+			return
+		}
+		if it.options.Reverse && it.upperBound != nil && bytes.Compare(key, it.upperBound) >= 0 {
+			continue
+		}
+		if it.indexIter.Value().IsExpired() {
+			continue
+		}
+		break
+	}
+}
+
+// pastEnd key是否已经超出了遍历方向上的终点
+func (it *IteratorUI) pastEnd(key []byte) bool {
+	if it.options.Reverse {
+		return it.lowerBound != nil && bytes.Compare(key, it.lowerBound) < 0
+	}
+	return it.upperBound != nil && bytes.Compare(key, it.upperBound) >= 0
+}
+
+// bounds 将LowerBound、UpperBound和Prefix合并成一个范围 [lower, upper)
+// 以prefix开头的key恰好是范围 [prefix, prefix的后继)，所以前缀遍历也可以直接Seek
+func (opts IteratorOptions) bounds() (lower []byte, upper []byte) {
+	lower, upper = opts.LowerBound, opts.UpperBound
+	if len(opts.Prefix) == 0 {
+		return lower, upper
+	}
+	if lower == nil || bytes.Compare(opts.Prefix, lower) > 0 {
+		lower = opts.Prefix
+	}
+	if prefixUpper := prefixSuccessor(opts.Prefix); prefixUpper != nil {
+		if upper == nil || bytes.Compare(prefixUpper, upper) < 0 {
+			upper = prefixUpper
+		}
+	}
+	return lower, upper
+}
+
+// contains key是否在遍历范围之内
+func (opts IteratorOptions) contains(key []byte) bool {
+	lower, upper := opts.bounds()
+	return (lower == nil || bytes.Compare(key, lower) >= 0) &&
+		(upper == nil || bytes.Compare(key, upper) < 0)
+}
+
+// prefixSuccessor 返回比所有以prefix开头的key都大的最小的key。prefix全为0xff时不存在，返回nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, prefix)
+			succ[i]++
+			return succ
 		}
 	}
+	return nil
 }
@@ -163,3 +163,118 @@ func TestDB_Iterator(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-iterator-bounds"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "b", "ba", "bb", "c", "d", "e"} {
+		err := db.Put([]byte(key), []byte(key))
+		assert.Nil(t, err)
+	}
+	collect := func(opts IteratorOptions) []string {
+		var keys []string
+		iter := db.NewIterator(opts)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	// [b, d)
+	iterOpts := DefaultIteratorOptions
+	iterOpts.LowerBound = []byte("b")
+	iterOpts.UpperBound = []byte("d")
+	assert.Equal(t, []string{"b", "ba", "bb", "c"}, collect(iterOpts))
+
+	// 反向遍历，上界不包含
+	iterOpts.Reverse = true
+	assert.Equal(t, []string{"c", "bb", "ba", "b"}, collect(iterOpts))
+
+	// Limit
+	iterOpts.Limit = 2
+	assert.Equal(t, []string{"c", "bb"}, collect(iterOpts))
+
+	// 前缀与范围同时生效
+	iterOpts = DefaultIteratorOptions
+	iterOpts.Prefix = []byte("b")
+	iterOpts.LowerBound = []byte("ba")
+	assert.Equal(t, []string{"ba", "bb"}, collect(iterOpts))
+	iterOpts.Reverse = true
+	assert.Equal(t, []string{"bb", "ba"}, collect(iterOpts))
+
+	// Seek 到范围之外时从范围的起点开始
+	iterOpts = DefaultIteratorOptions
+	iterOpts.LowerBound = []byte("c")
+	iter := db.NewIterator(iterOpts)
+	iter.Seek([]byte("a"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("c"), iter.Key())
+	iter.Close()
+}
+
+func TestTxn_Iterate_Bounds(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-iterator-bounds"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "c", "e"} {
+		err := db.Put([]byte(key), []byte(key))
+		assert.Nil(t, err)
+	}
+	txn := db.Begin()
+	for _, key := range []string{"b", "d", "f"} {
+		err := txn.Put([]byte(key), []byte(key))
+		assert.Nil(t, err)
+	}
+
+	iterOpts := DefaultIteratorOptions
+	iterOpts.LowerBound = []byte("b")
+	iterOpts.UpperBound = []byte("f")
+	iterOpts.Limit = 3
+	var keys []string
+	err = txn.Iterate(iterOpts, func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "d"}, keys)
+	assert.Nil(t, txn.Rollback())
+}
+
+func TestDB_Iterator_HashIndex(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-iterator-hash"
+	opts.IndexType = Hash
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, i := range []int{3, 1, 4, 2} {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	keys := db.ListKeys()
+	assert.Equal(t, 4, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i+1), key)
+	}
+
+	itOpts := DefaultIteratorOptions
+	itOpts.Reverse = true
+	iterator := db.NewIterator(itOpts)
+	defer iterator.Close()
+	iterator.Seek(utils.GetTestKey(3))
+	assert.Equal(t, utils.GetTestKey(3), iterator.Key())
+	iterator.Next()
+	assert.Equal(t, utils.GetTestKey(2), iterator.Key())
+}
@@ -2,17 +2,37 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
+	"context"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 )
 
+// mergeMovingFile merge目录中的这个文件存在，表示正在删除数据目录中的旧文件、把merge后的文件移进数据目录
+const mergeMovingFile = "merge-moving"
+
 // Merge clear up invalid records, and generate hint file
+// 开启了增量merge时，只重写无效数据比例最高的几个旧文件
 func (db *DB) Merge() error {
+	return db.MergeWithContext(context.Background(), DefaultMergeOptions)
+}
+
+// MergeWithContext 与Merge相同，但是可以限制读写速度、获取merge的进度
+// ctx被取消时中止merge，删除已经写出的merge文件
+func (db *DB) MergeWithContext(ctx context.Context, opts MergeOptions) error {
+	if db.options.ReadOnly {
+		return ErrDataBaseIsReadOnly
+	}
+	if db.options.IncrementalMerge {
+		return db.mergeIncrementally(ctx, opts)
+	}
 
 	// 数据库没有旧文件，直接返回
 	if len(db.olderFiles) == 0 {
@@ -30,6 +50,12 @@ func (db *DB) Merge() error {
 		return ErrMergeIsProgress
 	}
 
+	// 已过期的key先从索引中移除，计入无效数据
+	if err := db.removeExpiredKeysWithoutLock(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
 	// 检查是否达到了merge的阈值
 	totalSize, err := utils.GetDirSize(db.options.DirPath)
 	if err != nil {
@@ -58,11 +84,13 @@ func (db *DB) Merge() error {
 	// 正式开始merge
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
 
 	// 把当前活跃文件加入旧文件，创建一个新活跃文件
-	if err = db.activeFile.SyncFile(); err != nil {
+	if err = db.syncActiveFileWithoutLock(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
@@ -106,7 +134,16 @@ func (db *DB) Merge() error {
 	// 打开一个新的db实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
-	mergeOptions.SyncWrites = false // merge过程中，如果每次写入都sync，会非常慢。写入中发生错误时，merge是不成功的，所以不必每次都sync
+	mergeOptions.SyncPolicy = SyncPolicy{Mode: SyncNever} // merge过程中，如果每次写入都sync，会非常慢。写入中发生错误时，merge是不成功的，所以不必每次都sync
+	// mergeDB只用于重写数据，不需要后台任务
+	mergeOptions.ExpireSweepInterval = 0
+	mergeOptions.AutoMergeInterval = 0
+	mergeOptions.DataFileHint = false
+	mergeOptions.IndexSnapshot = false
+	// merge目录中的文件最后都会被移到数据目录，不能在其中创建磁盘索引文件
+	if mergeOptions.IndexType == BPTree {
+		mergeOptions.IndexType = BTree
+	}
 	// 打开一个mergeDB实例
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
@@ -115,10 +152,50 @@ func (db *DB) Merge() error {
 
 	// S3 正式开始merge
 	// 遍历所有需要merge的文件，重写有效数据,并创建hint文件
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := db.openFile(filepath.Join(mergePath, data.HintFileName), 0, fio.StandardFIO)
 	if err != nil {
+		_ = mergeDB.Close()
 		return err
 	}
+	// 记录每条有效记录被搬到了哪里，merge完成后据此更新索引
+	tracker := newMergeTracker(ctx, opts, len(filesToBeMerged))
+	moved, expired, err := db.rewriteMergeFiles(tracker, filesToBeMerged, mergeDB, hintFile)
+	_ = hintFile.Close()
+	_ = mergeDB.Close()
+	if err != nil {
+		// 没有完成（包括被取消）的merge文件没有用处，直接删除
+		_ = os.RemoveAll(mergePath)
+		return err
+	}
+
+	// 创建一个文件用于标识merge的完成（该文件存在代表merge完成，且其中记录了该次merge清理了哪几个旧文件）
+	mergeFinishedFile, err := db.openFile(filepath.Join(mergePath, data.MergeFinishedFile), 0, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer mergeFinishedFile.Close()
+	mergeFinishedRecord := &data.LogRecord{
+		Key:   []byte("merge finished"),
+		Value: []byte(strconv.Itoa(int(firstNonMergedFid))), // 比这个id小的文件都参与过merge
+	}
+	encodedMFR, _ := mergeFinishedFile.EncodeLogRecord(mergeFinishedRecord)
+	err = mergeFinishedFile.Write(encodedMFR)
+	if err != nil {
+		return err
+	}
+	err = mergeFinishedFile.SyncFile()
+	if err != nil {
+		return err
+	}
+
+	// S4 用merge后的文件替换旧文件，数据库不需要重启
+	return db.installMergeResult(mergePath, firstNonMergedFid, moved, expired)
+}
+
+// rewriteMergeFiles 把需要merge的文件中的有效记录重写到mergeDB中，并写入hint文件
+// moved记录被重写的记录，expired记录因为已经过期而没有重写、但还在索引中的记录
+func (db *DB) rewriteMergeFiles(tracker *mergeTracker, filesToBeMerged []*data.File, mergeDB *DB,
+	hintFile *data.File) (moved, expired []movedRecord, err error) {
 	for _, file := range filesToBeMerged {
 		var offset int64 = 0
 		for {
@@ -128,60 +205,51 @@ func (db *DB) Merge() error {
 				if err1 == io.EOF {
 					break
 				}
-				return err1
+				return nil, nil, err1
 			}
 			// 拿到实际的key
 			realKey, _ := decodeKeyWithSeqNo(record.Key)
 			// 将该key在内存索引中的位置信息与当前位置进行比较。检查记录是否有效,如果有效，则重写进merge目录的活跃文件；如果无效就忽略掉。
 			positionFromIndex := db.index.Get(realKey)
-			if positionFromIndex != nil &&
+			isValid := positionFromIndex != nil &&
 				positionFromIndex.Fid == file.Fid &&
-				positionFromIndex.Offset == offset {
+				positionFromIndex.Offset == offset
+			var written int64
+			// 已过期的记录不再重写
+			if isValid && record.IsExpired() {
+				expired = append(expired, movedRecord{key: append([]byte(nil), realKey...), oldFid: file.Fid, oldOffset: offset})
+			} else if isValid {
 				// 清除事务序列号
 				record.Key = encodeKeyWithSeqNo(realKey, NonTransaction)
 				// 拿到位置信息
 				pos, err2 := mergeDB.appendLogRecordWithoutLock(record)
 				if err2 != nil {
-					return err2
+					return nil, nil, err2
 				}
 				// 将位置信息写入hint文件
 
 				if err3 := hintFile.WriteHintFile(realKey, pos); err3 != nil {
-					return err3
+					return nil, nil, err3
 				}
+				moved = append(moved, movedRecord{key: append([]byte(nil), realKey...), oldFid: file.Fid, oldOffset: offset, pos: pos})
+				written = int64(pos.Size)
 			}
 			// 读取下一条记录
 			offset += size
+			if err = tracker.record(size, written, written > 0); err != nil {
+				return nil, nil, err
+			}
 		}
+		tracker.fileDone()
 	}
 
 	if err = hintFile.SyncFile(); err != nil {
-		return err
+		return nil, nil, err
 	}
 	if err = mergeDB.Sync(); err != nil {
-		return err
-	}
-
-	// 创建一个文件用于标识merge的完成（该文件存在代表merge完成，且其中记录了该次merge清理了哪几个旧文件）
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
-	if err != nil {
-		return err
-	}
-	mergeFinishedRecord := &data.LogRecord{
-		Key:   []byte("merge finished"),
-		Value: []byte(strconv.Itoa(int(firstNonMergedFid))), // 比这个id小的文件都参与过merge
+		return nil, nil, err
 	}
-	encodedMFR, _ := data.EncodeLogRecord(mergeFinishedRecord)
-	err = mergeFinishedFile.Write(encodedMFR)
-	if err != nil {
-		return err
-	}
-	err = mergeFinishedFile.SyncFile()
-	if err != nil {
-		return err
-	}
-	return nil
-
+	return moved, expired, nil
 }
 
 // Default normal files' path: "/tmp/kv"
@@ -202,10 +270,11 @@ func (db *DB) loadIndexFromHint() error {
 	}
 
 	// 打开hint文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := db.openFile(hintFileName, 0, fio.ReadOnlyFIO)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
 	// 读取记录，加载进index
 	var offset int64 = 0
 	for {
@@ -219,18 +288,28 @@ func (db *DB) loadIndexFromHint() error {
 		}
 		key := record.Key
 		decodedPosition := data.DecodeLogRecordPos(record.Value)
-		db.index.Put(key, decodedPosition)
 		offset += size
+		// merge之后才过期的记录不再放进索引
+		if decodedPosition.IsExpired() {
+			db.addInvalidSize(decodedPosition)
+			continue
+		}
+		db.index.Put(key, decodedPosition)
 	}
 	return nil
 }
 
 // 加载merge目录下的数据（应该在什么时候加载？？？只在启动时加载不对吧？？？）
-func (db *DB) loadMergeFiles() error {
+// 返回是否用merge后的文件替换了旧的数据文件
+func (db *DB) loadMergeFiles() (bool, error) {
 	mergePath := db.getMergePath()
 	// 如果不存在merge目录直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
-		return nil
+		return false, nil
+	}
+	// 只读模式下不改动merge目录，上一次merge的结果由写进程负责生效
+	if db.options.ReadOnly {
+		return false, db.checkMergeNotMoving()
 	}
 	defer func() {
 		_ = os.RemoveAll(mergePath)
@@ -239,7 +318,7 @@ func (db *DB) loadMergeFiles() error {
 	// 打开merge文件目录
 	DirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// 首先先查看merge完成的标识是否存在。如果已merge完成，就将文件名记录下来。
@@ -258,23 +337,55 @@ func (db *DB) loadMergeFiles() error {
 	// merge 没有完成直接返回
 	if !mergeFinished {
 		mergeFilesNames = nil
-		return nil
+		return false, nil
 	}
 
 	// 如果merge完成，就将原DB目录下已被merge的文件删掉，用merged files替代
+	if _, err = db.moveMergeFiles(mergePath, mergeFilesNames); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// moveMergeFiles 删除数据目录中已经被merge过的旧文件，把merge目录中的文件移进来，返回移进来的数据文件的id
+// 删除任何文件之前先在merge目录中写入并持久化一个标识，里面记录了要移进来的文件：
+// 1. 标识存在时只读模式的Open会失败，不会加载到只删了一部分的数据
+// 2. 移动到一半崩溃后重新执行时，标识中记录的、已经不在merge目录中的文件是已经移进来的，不能当作旧文件删掉
+// merge完成的标识最后移动
+func (db *DB) moveMergeFiles(mergePath string, mergeFilesNames []string) ([]uint32, error) {
 	firstNonMergedFid, err := db.GetFirstNonMergedFid(mergePath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	movingFile := filepath.Join(mergePath, mergeMovingFile)
+	movingNames, err := readMergeMovingFile(movingFile)
+	if os.IsNotExist(err) {
+		for _, name := range mergeFilesNames {
+			if name != data.MergeFinishedFile && name != mergeMovingFile {
+				movingNames = append(movingNames, name)
+			}
+		}
+		err = writeMergeMovingFile(movingFile, movingNames)
+	}
+	if err != nil {
+		return nil, err
+	}
+	moving := make(map[string]bool, len(movingNames))
+	for _, name := range movingNames {
+		moving[name] = true
 	}
 
-	// 删除比firstNonMergedFid小的旧文件
+	// 删除比firstNonMergedFid小的旧文件，旧数据文件的hint文件也一起删掉，merge后的文件会复用这些文件id
 	for fid := uint32(0); fid < firstNonMergedFid; fid++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fid)
-		// 如果旧数据文件存在，就将它删除掉。
-		if _, err1 := os.Stat(fileName); err1 == nil {
-			err = os.Remove(fileName)
-			if err != nil {
-				return err
+		for _, fileName := range []string{data.GetDataFileName(db.options.DirPath, fid), data.GetDataFileHintName(db.options.DirPath, fid)} {
+			name := filepath.Base(fileName)
+			if _, err1 := os.Stat(filepath.Join(mergePath, name)); moving[name] && os.IsNotExist(err1) {
+				// 上次已经移进来的文件
+				continue
+			}
+			if err = os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+				return nil, err
 			}
 		}
 	}
@@ -282,24 +393,163 @@ func (db *DB) loadMergeFiles() error {
 	// 加载新的merged后的数据文件
 	// /tmp/kv          01.data  02.data  03.data
 	// /tmp/kv-merge
+	var fids []uint32
 	for _, mergedFile := range mergeFilesNames {
+		if mergedFile == data.MergeFinishedFile || mergedFile == mergeMovingFile {
+			continue
+		}
 		src := filepath.Join(mergePath, mergedFile)
 		dest := filepath.Join(db.options.DirPath, mergedFile) // 新的file又是从0开始的？
-		err = os.Rename(src, dest)
-		if err != nil {
-			return err
+		if err = os.Rename(src, dest); err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(mergedFile, data.FileSuffix) {
+			fid, err1 := strconv.Atoi(strings.TrimSuffix(mergedFile, data.FileSuffix))
+			if err1 != nil {
+				return nil, ErrDataFileDirectoryCorrupted
+			}
+			fids = append(fids, uint32(fid))
+		}
+	}
+	err = os.Rename(filepath.Join(mergePath, data.MergeFinishedFile), filepath.Join(db.options.DirPath, data.MergeFinishedFile))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fids[i] < fids[j]
+	})
+	return fids, nil
+}
+
+// checkMergeNotMoving 写进程正在删除旧文件、移动merge后的文件时，数据目录中的文件还不完整，只读模式的Open直接失败
+func (db *DB) checkMergeNotMoving() error {
+	if _, err := os.Stat(filepath.Join(db.getMergePath(), mergeMovingFile)); err == nil {
+		return ErrMergeIsProgress
+	}
+	return nil
+}
+
+// writeMergeMovingFile 写入并持久化merge目录中的移动标识，names是要移进数据目录的文件
+func writeMergeMovingFile(fileName string, names []string) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(strings.Join(names, "\n")); err == nil {
+		err = file.Sync()
+	}
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	// 标识本身在目录中的记录也要持久化
+	dir, err := os.Open(filepath.Dir(fileName))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// readMergeMovingFile 读取移动标识中记录的文件，标识不存在时返回的错误满足os.IsNotExist
+func readMergeMovingFile(fileName string) ([]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil || len(content) == 0 {
+		return nil, err
+	}
+	return strings.Split(string(content), "\n"), nil
+}
+
+// installMergeResult merge完成后立即用merge后的文件替换旧文件，并把索引更新到新文件中
+// 旧文件被快照引用时还不能删除，此时merge的结果留在merge目录中，下次打开数据库时再生效
+func (db *DB) installMergeResult(mergePath string, firstNonMergedFid uint32, moved, expired []movedRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for fid := range db.filePins {
+		if fid < firstNonMergedFid {
+			return nil
+		}
+	}
+	entries, err := os.ReadDir(mergePath)
+	if err != nil {
+		return err
+	}
+	var mergeFilesNames []string
+	for _, entry := range entries {
+		if entry.Name() != fileLockName {
+			mergeFilesNames = append(mergeFilesNames, entry.Name())
 		}
 	}
+
+	// 等正在生成的hint写完，之后旧文件的hint会被删掉
+	db.hintMu.Lock()
+	defer db.hintMu.Unlock()
+
+	// 先移动并打开merge后的文件，全部成功后再替换旧文件
+	// 移动过程中旧文件已经从目录中删除，但是已经打开的旧文件依然可以读取，出错时索引仍然可用，下次打开数据库时继续移动
+	fids, err := db.moveMergeFiles(mergePath, mergeFilesNames)
+	if err != nil {
+		return err
+	}
+	var mergedFiles []*data.File
+	for _, fid := range fids {
+		dataFile, err1 := db.openDataFile(db.options.DirPath, fid, fio.StandardFIO)
+		if err1 != nil {
+			for _, file := range mergedFiles {
+				_ = file.Close()
+			}
+			return err1
+		}
+		mergedFiles = append(mergedFiles, dataFile)
+	}
+	_ = os.RemoveAll(mergePath)
+
+	// 关闭参与了merge的旧文件，它们的无效数据随之清除
+	for fid, file := range db.olderFiles {
+		if fid < firstNonMergedFid {
+			_ = file.Close()
+			delete(db.olderFiles, fid)
+			db.invalidSize -= db.fileInvalidSize[fid]
+			delete(db.fileInvalidSize, fid)
+		}
+	}
+	db.dropDataFileHints(func(file *data.File) bool {
+		return file.Fid < firstNonMergedFid
+	})
+	for _, dataFile := range mergedFiles {
+		db.olderFiles[dataFile.Fid] = dataFile
+	}
+
+	// merge期间没有被修改过的key改为指向merge后的文件；过期没有重写的key直接从索引中删除
+	if err = db.updateIndex(func(idx index.Writer) error {
+		db.updateMovedIndex(idx, moved)
+		for _, record := range expired {
+			if pos := idx.Get(record.key); pos != nil && pos.Fid == record.oldFid && pos.Offset == record.oldOffset {
+				idx.Delete(record.key)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if db.options.DataFileHint {
+		db.writeDataFileHintsInBackground(mergedFiles, false)
+	}
 	return nil
 }
 
 // GetFirstNonMergedFid 在merge目录中的mergeFinishedFile中，找到第一个未被merge的文件的id
 func (db *DB) GetFirstNonMergedFid(mergePath string) (uint32, error) {
 
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := db.openFile(filepath.Join(mergePath, data.MergeFinishedFile), 0, fio.ReadOnlyFIO)
 	if err != nil {
 		return 0, err
 	}
+	defer mergeFinishedFile.Close()
 
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
@@ -0,0 +1,68 @@
+package bitcask_go
+
+import (
+	"context"
+	"time"
+)
+
+// MergeProgress merge的进度
+type MergeProgress struct {
+	FilesTotal   int    // 需要处理的文件数
+	FilesDone    int    // 已经处理完的文件数
+	BytesScanned int64  // 已经从旧文件中读取的数据量
+	BytesWritten int64  // 已经写入新文件的数据量
+	KeysKept     uint64 // 重写到新文件中的有效key数量
+}
+
+// mergeSleepGranularity 限速时欠下的等待时间累计超过这个值才真正sleep，避免每条记录都创建定时器
+const mergeSleepGranularity = 10 * time.Millisecond
+
+// mergeTracker 记录merge的进度，按配置限制读写速度，并在ctx被取消时让merge尽快停下
+type mergeTracker struct {
+	ctx      context.Context
+	opts     MergeOptions
+	progress MergeProgress
+	start    time.Time
+}
+
+func newMergeTracker(ctx context.Context, opts MergeOptions, filesTotal int) *mergeTracker {
+	return &mergeTracker{
+		ctx:      ctx,
+		opts:     opts,
+		progress: MergeProgress{FilesTotal: filesTotal},
+		start:    time.Now(),
+	}
+}
+
+// record 记录处理完一条记录：读取了scanned字节，写入了written字节，keyKept表示是否保留了一个有效key
+// 读写得太快时等待到限速允许的时间；ctx被取消时返回ctx的错误
+func (t *mergeTracker) record(scanned, written int64, keyKept bool) error {
+	t.progress.BytesScanned += scanned
+	t.progress.BytesWritten += written
+	if keyKept {
+		t.progress.KeysKept++
+	}
+
+	if t.opts.BytesPerSecond > 0 {
+		// 按限速，处理完已经读写的数据量最早应该在什么时候
+		total := t.progress.BytesScanned + t.progress.BytesWritten
+		due := t.start.Add(time.Duration(float64(total) / float64(t.opts.BytesPerSecond) * float64(time.Second)))
+		if wait := time.Until(due); wait > mergeSleepGranularity {
+			timer := time.NewTimer(wait)
+			select {
+			case <-t.ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}
+	return t.ctx.Err()
+}
+
+// fileDone 处理完一个文件，报告进度
+func (t *mergeTracker) fileDone() {
+	t.progress.FilesDone++
+	if t.opts.Progress != nil {
+		t.opts.Progress(t.progress)
+	}
+}
@@ -1,10 +1,16 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"context"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func Test_MergeAllDataValidOrInvalid(t *testing.T) {
@@ -51,7 +57,7 @@ func Test_MergeSomeDataValid(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 
-	/*for i := 0; i < 5*32*1024; i++ {
+	for i := 0; i < 5*32*1024; i++ {
 		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
 		assert.Nil(t, err)
 	}
@@ -64,18 +70,18 @@ func Test_MergeSomeDataValid(t *testing.T) {
 	for i := 2 * 32 * 1024; i < 4*32*1024; i++ {
 		err := db.Delete(utils.GetTestKey(i))
 		assert.Nil(t, err)
-	}*/
+	}
 
-	/*err = db.Merge()
-	assert.Nil(t, err)*/
+	err = db.Merge()
+	assert.Nil(t, err)
 
 	fid, err := db.GetFirstNonMergedFid("/tmp/DB-mergeTest")
 	assert.Nil(t, err)
 	t.Log("FirstNonMergedFid in merged-mark file:", fid)
 
-	/*// 重启校验
+	// 重启校验
 	err = db.Close()
-	assert.Nil(t, err)*/
+	assert.Nil(t, err)
 }
 
 func Test_PutDuringMerge(t *testing.T) {
@@ -85,7 +91,7 @@ func Test_PutDuringMerge(t *testing.T) {
 	opts.MergeRatioThreshold = 0
 
 	db, err := Open(opts)
-	//defer destroyDB(db)
+	defer destroyDB(db)
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 
@@ -106,10 +112,6 @@ func Test_PutDuringMerge(t *testing.T) {
 	keys := db.ListKeys()
 	t.Log("size of valid keys:", len(keys))
 
-	fid, err := db.GetFirstNonMergedFid("/tmp/DB-mergeTest")
-	assert.Nil(t, err)
-	t.Log("FirstNonMergedFid in merged-mark file:", fid)
-
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
 	go func() {
@@ -128,6 +130,10 @@ func Test_PutDuringMerge(t *testing.T) {
 	assert.Nil(t, err)
 	wg.Wait()
 
+	fid, err := db.GetFirstNonMergedFid("/tmp/DB-mergeTest")
+	assert.Nil(t, err)
+	t.Log("FirstNonMergedFid in merged-mark file:", fid)
+
 }
 
 func Test_MergeRatioThreshold(t *testing.T) {
@@ -164,3 +170,293 @@ func Test_MergeRatioThreshold(t *testing.T) {
 	t.Log("err:", err)
 	assert.NotNil(t, err)
 }
+
+func TestDB_MergeOnline(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-merge-online"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	err = db.PutWithTTL(utils.GetTestKey(5000), utils.RandomValue(128), 50*time.Millisecond)
+	assert.Nil(t, err)
+	statBefore := db.Stat()
+	time.Sleep(100 * time.Millisecond)
+
+	// merge期间的写入不受影响
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+	}()
+	err = db.Merge()
+	assert.Nil(t, err)
+	wg.Wait()
+	for i := 0; i < 500; i++ {
+		expected[string(utils.GetTestKey(i))] = utils.GetTestKey(i)
+	}
+
+	// 不需要重启，旧文件已经被删除，merge目录也已经清理
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	statAfter := db.Stat()
+	assert.True(t, statAfter.DataFileNum < statBefore.DataFileNum)
+	assert.True(t, statAfter.OccupiedDiscSize < statBefore.OccupiedDiscSize)
+	checkIncrementalMergeTestData(t, db, expected)
+	checkFileStats(t, db)
+	_, err = db.Get(utils.GetTestKey(5000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_MergeWithSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-merge-snapshot"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+
+	// 旧文件被快照引用，merge的结果留到下次打开数据库时生效
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFile))
+	assert.Nil(t, err)
+	val, err := snap.Get(utils.GetTestKey(2500))
+	assert.Nil(t, err)
+	assert.Equal(t, expected[string(utils.GetTestKey(2500))], val)
+	checkIncrementalMergeTestData(t, db, expected)
+	snap.Release()
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_MoveMergeFilesResume(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-merge-resume"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+	snap.Release()
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 模拟移动merge后的文件时崩溃：标识已经写入，只删掉了一个旧文件，第一个数据文件已经移进了数据目录
+	mergePath := db.getMergePath()
+	entries, err := os.ReadDir(mergePath)
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != data.MergeFinishedFile {
+			names = append(names, entry.Name())
+		}
+	}
+	assert.Equal(t, data.GetDataFileName("", 1), names[0])
+	assert.Nil(t, writeMergeMovingFile(filepath.Join(mergePath, mergeMovingFile), names))
+	assert.Nil(t, os.Remove(data.GetDataFileName(opts.DirPath, 2)))
+	err = os.Rename(filepath.Join(mergePath, names[0]), filepath.Join(db.options.DirPath, names[0]))
+	assert.Nil(t, err)
+
+	// 此时数据目录中的文件不完整，只读模式无法打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrMergeIsProgress, err)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_MergeInstallFailure(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-merge-install-failure"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	// 数据目录中和merge的hint文件同名的目录让移动merge后的文件失败，此时旧文件已经从目录中删除
+	hintDir := filepath.Join(opts.DirPath, data.HintFileName)
+	assert.Nil(t, os.MkdirAll(filepath.Join(hintDir, "x"), os.ModePerm))
+	err = db.Merge()
+	assert.NotNil(t, err)
+	// 已经打开的旧文件依然可以读取
+	checkIncrementalMergeTestData(t, db, expected)
+	assert.Nil(t, db.Close())
+
+	// 下次打开时继续移动
+	assert.Nil(t, os.RemoveAll(hintDir))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_MergeWithContext(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-merge-context"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+
+	// 已经取消的ctx，merge直接中止，merge目录被清理
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeWithContext(ctx, DefaultMergeOptions)
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, db.isMerging)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	checkIncrementalMergeTestData(t, db, expected)
+
+	// 处理完第一个文件后取消
+	ctx, cancel = context.WithCancel(context.Background())
+	err = db.MergeWithContext(ctx, MergeOptions{Progress: func(progress MergeProgress) {
+		cancel()
+	}})
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	checkIncrementalMergeTestData(t, db, expected)
+
+	// 限速并报告进度
+	var progresses []MergeProgress
+	start := time.Now()
+	err = db.MergeWithContext(context.Background(), MergeOptions{
+		BytesPerSecond: 4 * 1024 * 1024,
+		Progress: func(progress MergeProgress) {
+			progresses = append(progresses, progress)
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, len(progresses) > 1)
+	last := progresses[len(progresses)-1]
+	for i, progress := range progresses {
+		assert.Equal(t, i+1, progress.FilesDone)
+		assert.Equal(t, last.FilesTotal, progress.FilesTotal)
+	}
+	assert.Equal(t, uint64(2200), last.KeysKept)
+	assert.True(t, last.BytesWritten > 0 && last.BytesWritten < last.BytesScanned)
+	limit := time.Duration(float64(last.BytesScanned+last.BytesWritten) / (4 * 1024 * 1024) * float64(time.Second))
+	assert.True(t, time.Since(start) >= limit-mergeSleepGranularity)
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_MergeWithContext_Incremental(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-merge-context-incremental"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	opts.IncrementalMerge = true
+	opts.IncrementalMergeMaxFiles = 0
+	opts.MergeRatioThreshold = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := writeIncrementalMergeTestData(t, db)
+	stats, err := db.FileStats()
+	assert.Nil(t, err)
+
+	// 取消后，原文件保持不变，也不会留下重写了一半的文件
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeWithContext(ctx, DefaultMergeOptions)
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, db.isMerging)
+	stats2, err := db.FileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, stats, stats2)
+	entries, err := os.ReadDir(opts.DirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasSuffix(entry.Name(), compactFileSuffix))
+	}
+
+	var last MergeProgress
+	err = db.MergeWithContext(context.Background(), MergeOptions{Progress: func(progress MergeProgress) {
+		last = progress
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, last.FilesTotal, last.FilesDone)
+	assert.True(t, last.BytesScanned > 0)
+	checkIncrementalMergeTestData(t, db, expected)
+}
+
+func TestDB_MergeDuringWrites_BPTree(t *testing.T) {
+	for _, incremental := range []bool{false, true} {
+		opts := DefaultOptions
+		opts.DirPath = "/tmp/kv/DB-merge-bptree-writes"
+		opts.DataFileSize = 64 * 1024
+		opts.IndexType = BPTree
+		opts.MergeRatioThreshold = 0
+		opts.IncrementalMerge = incremental
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		// merge读取索引时没有持有db锁，与写操作的bolt写事务同时进行
+		value := utils.RandomValue(128)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 3000; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i%500), value))
+			}
+		}()
+		for merging := true; merging; {
+			select {
+			case <-done:
+				merging = false
+			default:
+				if err = db.Merge(); err != nil {
+					assert.Equal(t, ErrMergeRatioUnreached, err)
+				}
+			}
+		}
+		for i := 0; i < 500; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		destroyDB(db)
+	}
+}
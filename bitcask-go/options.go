@@ -1,9 +1,18 @@
 package bitcask_go
 
+import (
+	"bitcask-go/data"
+	"time"
+)
+
 type Options struct {
 	// 数据库数据目录
 	DirPath string
 
+	// 是否以只读方式打开：不加文件锁，不修改数据目录，所有写操作都返回ErrDataBaseIsReadOnly
+	// 可以在写进程运行时打开它的数据目录（或者一份备份），读到的是打开那一刻的数据
+	ReadOnly bool
+
 	// 数据文件的大小
 	DataFileSize int64
 
@@ -13,16 +22,66 @@ type Options struct {
 	// 每写多少字节自动持久化
 	SyncPerBytes uint
 
+	// 持久化策略，不设置时由SyncWrites和SyncPerBytes决定
+	SyncPolicy SyncPolicy
+
+	// 旧数据文件中有损坏的记录时如何处理，默认Open直接失败
+	// 活跃文件末尾没写完的记录（进程崩溃导致）总是会被截断，不受它影响
+	RecoveryMode RecoveryMode
+
 	// 索引类型
 	IndexType IndexerType
 
 	// 是否在启动时使用内存映射MemoryMap加速加载
 	MMapAtStartupNeeded bool
 
+	// 数据文件写满变为旧文件后，是否在后台为它生成hint文件，加快启动时的索引加载
+	DataFileHint bool
+
+	// 启动时并发解码旧数据文件的goroutine数量，不大于1时按顺序逐个加载
+	IndexLoadWorkers int
+
+	// 关闭时是否把内存索引写入索引快照文件，下次打开时直接加载快照，只需要重放快照之后追加的记录
+	IndexSnapshot bool
+
+	// value的压缩方式。只影响之后写入的记录，merge时旧记录会按照当前的压缩方式重新压缩
+	Compression CompressionType
+
+	// value至少有多少字节才压缩，更短的value直接保存
+	CompressionMinSize int
+
+	// 加密数据文件、hint文件和merge文件的密钥来源，为nil表示不加密
+	// 每条记录用AES-GCM加密，文件头中记录所用密钥的id；merge时旧文件会用当前的密钥重新加密
+	Encryption KeyProvider
+
 	// merge阈值
 	MergeRatioThreshold float32
+
+	// merge时是否只重写无效数据比例最高的几个旧文件（增量merge），而不是重写整个数据库
+	// 此时MergeRatioThreshold是单个文件中无效数据所占比例的阈值
+	IncrementalMerge bool
+
+	// 增量merge每次最多重写多少个文件，为0表示不限制
+	IncrementalMergeMaxFiles int
+
+	// 后台自动merge的检查间隔，为0表示不开启自动merge
+	AutoMergeInterval time.Duration
+
+	// 自动merge时，可回收的数据量（字节）至少要达到多少
+	AutoMergeMinReclaimable int64
+
+	// 允许自动merge的时间段（本地时间），形如 "02:00-05:00"，可以跨越零点。为空表示不限制
+	AutoMergeWindow string
 	// hash table 的初始容量？
 
+	// 后台清理过期key的间隔，为0表示不开启后台清理
+	ExpireSweepInterval time.Duration
+
+	// 每轮清理最多检查多少个key，为0表示不限制
+	ExpireSweepMaxKeys int
+
+	// 每轮清理最多运行多长时间，为0表示不限制
+	ExpireSweepMaxDuration time.Duration
 }
 
 // IteratorOptions 索引迭代器配置参数
@@ -31,6 +90,20 @@ type IteratorOptions struct {
 	Prefix []byte
 	// 是否反向遍历
 	Reverse bool
+	// 遍历范围的下界（包含），为nil时不限制
+	LowerBound []byte
+	// 遍历范围的上界（不包含），为nil时不限制
+	UpperBound []byte
+	// 最多遍历多少个key，为0时不限制
+	Limit int
+}
+
+// MergeOptions 单次merge的配置
+type MergeOptions struct {
+	// 每秒最多读写多少字节（读取旧文件与写入新文件合计），为0表示不限制
+	BytesPerSecond int64
+	// 每处理完一个文件调用一次，报告merge的进度，为nil时不报告
+	Progress func(progress MergeProgress)
 }
 
 type WriteBatchOptions struct {
@@ -40,6 +113,57 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// SyncPolicy 数据的持久化策略。不论哪种策略，活跃文件写满变为旧文件时都会持久化
+type SyncPolicy struct {
+	// 持久化的方式
+	Mode SyncMode
+	// SyncEveryNBytes时，每写多少字节持久化一次
+	Bytes uint
+	// SyncInterval时，后台每隔多久持久化一次活跃文件
+	Interval time.Duration
+}
+
+type SyncMode = int8
+
+const (
+	// SyncDefault 未设置，由SyncWrites和SyncPerBytes决定
+	SyncDefault SyncMode = iota
+	// SyncAlways 每次写入后立即持久化，并发的写入通过组提交共用一次持久化
+	SyncAlways
+	// SyncEveryNBytes 每写入Bytes个字节持久化一次
+	SyncEveryNBytes
+	// SyncInterval 后台goroutine每隔Interval持久化一次活跃文件
+	SyncInterval
+	// SyncNever 不主动持久化，交给操作系统
+	SyncNever
+)
+
+type RecoveryMode = int8
+
+const (
+	// RecoveryStrict 数据文件中有损坏的记录时Open失败（活跃文件末尾崩溃时没写完的记录总是被截断）
+	RecoveryStrict RecoveryMode = iota
+	// RecoverySkipCorrupted 跳过数据文件中从损坏处到文件末尾的数据并记录日志，之后的merge会把它们清理掉；活跃文件直接截断到损坏处
+	RecoverySkipCorrupted
+)
+
+type CompressionType = data.Codec
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = data.CodecNone
+	// SnappyCompression snappy压缩，速度快
+	SnappyCompression CompressionType = data.CodecSnappy
+	// ZstdCompression zstd压缩，压缩率更高
+	ZstdCompression CompressionType = data.CodecZstd
+)
+
+// KeyProvider 提供加密用的密钥，见data.KeyProvider
+type KeyProvider = data.KeyProvider
+
+// KeyRing 保存所有密钥的KeyProvider，新文件使用CurrentID对应的密钥
+type KeyRing = data.KeyRing
+
 type IndexerType = int8
 
 const (
@@ -51,16 +175,37 @@ const (
 	ART
 	// SkipList B+ 树索引
 	SkipList
+	// BPTree 磁盘上的B+树索引，key的数量不受内存限制，重新打开时不需要重建索引
+	BPTree
 )
 
 var DefaultOptions = Options{
 	DirPath:             "/tmp/kv/DB",     // 更换一个路径
 	DataFileSize:        32 * 1024 * 1024, // 32MB
+	ReadOnly:            false,
 	SyncWrites:          false,
 	SyncPerBytes:        0,
+	SyncPolicy:          SyncPolicy{Mode: SyncDefault},
 	IndexType:           BTree,
 	MMapAtStartupNeeded: true,
+	DataFileHint:        true,
+	IndexLoadWorkers:    0,
+	IndexSnapshot:       false,
 	MergeRatioThreshold: 0.6,
+
+	Compression:        NoCompression,
+	CompressionMinSize: 256,
+
+	IncrementalMerge:         false,
+	IncrementalMergeMaxFiles: 4,
+
+	AutoMergeInterval:       0,
+	AutoMergeMinReclaimable: 0,
+	AutoMergeWindow:         "",
+
+	ExpireSweepInterval:    0,
+	ExpireSweepMaxKeys:     1000,
+	ExpireSweepMaxDuration: 25 * time.Millisecond,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -68,6 +213,11 @@ var DefaultIteratorOptions = IteratorOptions{
 	Reverse: false,
 }
 
+var DefaultMergeOptions = MergeOptions{
+	BytesPerSecond: 0,
+	Progress:       nil,
+}
+
 var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+)
+
+// decodedDataFile 一个旧数据文件解码后的结果
+type decodedDataFile struct {
+	records []*data.TransactionRecord
+	err     error
+}
+
+// dataFileDecoder 启动时并发地解码多个旧数据文件（每个文件一个goroutine，最多同时解码workers个），
+// 再按文件id的顺序把结果交给调用方应用到索引，保证后写的数据覆盖先写的、事务记录的分组不被打乱
+type dataFileDecoder struct {
+	results []chan decodedDataFile // 与传入的文件一一对应
+	sem     chan struct{}          // 限制已经开始解码、但还没有被取走的文件数量，从而限制内存占用
+	next    int                    // 下一个要取走的文件下标
+}
+
+// newDataFileDecoder 开始并发解码files（需要按文件id从小到大排好序）
+func (db *DB) newDataFileDecoder(files []*data.File, workers int) *dataFileDecoder {
+	d := &dataFileDecoder{
+		results: make([]chan decodedDataFile, len(files)),
+		sem:     make(chan struct{}, workers),
+	}
+	for i := range d.results {
+		d.results[i] = make(chan decodedDataFile, 1)
+	}
+
+	go func() {
+		for i, file := range files {
+			d.sem <- struct{}{}
+			go func(i int, file *data.File) {
+				records, err := db.decodeDataFile(file)
+				d.results[i] <- decodedDataFile{records: records, err: err}
+			}(i, file)
+		}
+	}()
+	return d
+}
+
+// Next 按顺序取出下一个文件的解码结果
+func (d *dataFileDecoder) Next() ([]*data.TransactionRecord, error) {
+	result := <-d.results[d.next]
+	d.next++
+	<-d.sem
+	return result.records, result.err
+}
+
+// Close 等待剩下的文件解码完并丢弃结果，避免goroutine泄漏（加载中途出错时使用）
+func (d *dataFileDecoder) Close() {
+	for d.next < len(d.results) {
+		_, _ = d.Next()
+	}
+}
+
+// decodeDataFile 读出一个旧数据文件中所有记录（不含value）及其位置信息，优先使用该文件的hint文件
+func (db *DB) decodeDataFile(dataFile *data.File) ([]*data.TransactionRecord, error) {
+	if records, ok := db.readDataFileHint(dataFile); ok {
+		return records, nil
+	}
+
+	var records []*data.TransactionRecord
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || db.skipCorruption(dataFile.Fid, offset, err) {
+				break
+			}
+			return nil, err
+		}
+		// 只保留key，value不需要（key与value在同一块内存中，拷贝一份以便释放value）
+		logRecord.Key = append([]byte(nil), logRecord.Key...)
+		logRecord.Value = nil
+		records = append(records, &data.TransactionRecord{
+			Record:   logRecord,
+			Position: &data.LogRecordPos{Fid: dataFile.Fid, Offset: offset, Size: uint32(size), Expire: logRecord.Expire},
+		})
+		offset += size
+	}
+	return records, nil
+}
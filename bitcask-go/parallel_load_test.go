@@ -0,0 +1,41 @@
+package bitcask_go
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOpen_IndexLoadWorkers(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-parallel-load"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	writeHintTestData(t, db)
+	stat := db.Stat()
+	keys := db.ListKeys()
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.True(t, stat.DataFileNum > 4)
+
+	// 扫描数据文件并发加载、使用hint文件并发加载，结果都与顺序加载一致
+	for _, hint := range []bool{false, true} {
+		opts.DataFileHint = hint
+		opts.IndexLoadWorkers = 3
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+		assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+		assert.Equal(t, keys, db.ListKeys())
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(keys[0])
+	assert.Nil(t, err)
+	assert.Equal(t, keys[0], val)
+}
@@ -0,0 +1,137 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// listDir 数据目录中的文件名及其大小
+func listDir(t *testing.T, dir string) map[string]int64 {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	files := make(map[string]int64)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		assert.Nil(t, err)
+		files[entry.Name()] = info.Size()
+	}
+	return files
+}
+
+// openFileCount 当前进程打开的文件描述符数量，不支持时返回-1
+func openFileCount() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-read-only"
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileHint = false
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	before := listDir(t, opts.DirPath)
+	fds := openFileCount()
+
+	// 写进程运行时，可以同时打开多个只读的实例
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roOpts.MMapAtStartupNeeded = true
+	reader1, err := Open(roOpts)
+	assert.Nil(t, err)
+	roOpts.MMapAtStartupNeeded = false
+	reader2, err := Open(roOpts)
+	assert.Nil(t, err)
+
+	for _, reader := range []*DB{reader1, reader2} {
+		assert.Equal(t, uint(900), reader.Stat().KeyNum)
+		for i := 0; i < 1000; i += 50 {
+			val, err := reader.Get(utils.GetTestKey(i))
+			if i < 100 {
+				assert.Equal(t, ErrKeyNotFound, err)
+				continue
+			}
+			expected, _ := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, expected, val)
+		}
+
+		// 拒绝所有写操作
+		assert.Equal(t, ErrDataBaseIsReadOnly, reader.Put(utils.GetTestKey(0), utils.RandomValue(8)))
+		assert.Equal(t, ErrDataBaseIsReadOnly, reader.Delete(utils.GetTestKey(200)))
+		wb := reader.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.PendingPut(utils.GetTestKey(0), utils.RandomValue(8)))
+		assert.Equal(t, ErrDataBaseIsReadOnly, wb.Commit())
+		assert.Equal(t, ErrDataBaseIsReadOnly, reader.Merge())
+		assert.Nil(t, reader.Sync())
+	}
+
+	// 只读实例没有修改数据目录
+	assert.Equal(t, before, listDir(t, opts.DirPath))
+
+	// 读到的是打开那一刻的数据，写进程之后的写入不可见
+	assert.Nil(t, db.Put(utils.GetTestKey(5000), utils.RandomValue(128)))
+	_, err = reader1.Get(utils.GetTestKey(5000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, reader1.Close())
+	assert.Nil(t, reader2.Close())
+	// 只读实例关闭后没有遗留打开的文件
+	assert.Equal(t, fds, openFileCount())
+	// 写进程不受影响
+	assert.Nil(t, db.Put(utils.GetTestKey(5001), utils.RandomValue(128)))
+}
+
+func TestDB_ReadOnly_Backup(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-read-only-backup"
+	opts.IndexType = BPTree
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// 磁盘索引被写进程占用，只读实例在内存中重建索引
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), reader.Stat().KeyNum)
+	assert.Nil(t, reader.Close())
+
+	// 目录不存在时不会创建
+	roOpts.DirPath = "/tmp/kv/DB-read-only-not-exist"
+	_, err = Open(roOpts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(roOpts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 写进程正在移动merge后的文件时，数据目录还不完整
+	roOpts.DirPath = opts.DirPath
+	mergePath := db.getMergePath()
+	assert.Nil(t, os.MkdirAll(mergePath, os.ModePerm))
+	defer os.RemoveAll(mergePath)
+	assert.Nil(t, os.WriteFile(filepath.Join(mergePath, mergeMovingFile), nil, 0644))
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrMergeIsProgress, err)
+	_, err = os.Stat(filepath.Join(mergePath, mergeMovingFile))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,55 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"log"
+)
+
+// isCorruption 读取记录时遇到的错误是否说明记录已经损坏
+func isCorruption(err error) bool {
+	return err == data.ErrInvalidCRC || err == data.ErrRecordTruncated || err == data.ErrDecryptionFailed
+}
+
+// isTornTail 读取活跃文件中offset处的记录失败时，这条记录是否是崩溃时没有写完的最后一条记录
+// 只有一直延伸到文件末尾的记录才可能是没写完的：超出了文件末尾，或者正好是文件中的最后一条记录。
+// 文件中间的记录损坏时，它之后还有完整的记录，不能当作没写完的记录截断掉
+func isTornTail(file *data.File, offset, size int64, err error) (bool, error) {
+	if err == data.ErrRecordTruncated {
+		return true, nil
+	}
+	if err != data.ErrInvalidCRC && err != data.ErrDecryptionFailed {
+		return false, nil
+	}
+	fileSize, err := file.IOManager.Size()
+	if err != nil {
+		return false, err
+	}
+	return offset+size >= fileSize, nil
+}
+
+// skipCorruption 打开数据库、加载数据文件时遇到损坏的记录，是否把损坏处当作文件末尾继续（RecoverySkipCorrupted）
+// 只用于加载索引：merge和生成hint时遇到损坏总是失败，否则重写后删除旧文件会让损坏处之后的数据永久丢失
+func (db *DB) skipCorruption(fid uint32, offset int64, err error) bool {
+	if !isCorruption(err) || db.options.RecoveryMode != RecoverySkipCorrupted {
+		return false
+	}
+	log.Printf("skip the corrupted data in data file %d from offset %d: %v", fid, offset, err)
+	return true
+}
+
+// truncateTornTail 活跃文件在validSize之后的数据是崩溃时没有写完的记录（或者RecoverySkipCorrupted时跳过的损坏数据），
+// 截断到最后一条完整的记录，之后的写入紧接在它后面。只读模式下不修改数据文件，加载索引时同样忽略这部分数据
+func (db *DB) truncateTornTail(validSize int64) error {
+	size, err := db.activeFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	if validSize >= size {
+		return nil
+	}
+	log.Printf("discard %d bytes of torn tail in active data file %d", size-validSize, db.activeFile.Fid)
+	if db.options.ReadOnly {
+		return nil
+	}
+	return db.activeFile.Truncate(db.options.DirPath, validSize)
+}
@@ -0,0 +1,203 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// appendToFile 在文件末尾追加数据，模拟崩溃时没写完的记录
+func appendToFile(t *testing.T, fileName string, buf []byte) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+// flipLastByte 翻转记录的最后一个字节，读取时crc校验失败
+func flipLastByte(record []byte) {
+	record[len(record)-1] ^= 0xff
+}
+
+// corruptKeySize 把记录header中key的长度改成一个超出文件末尾的值
+func corruptKeySize(record []byte) {
+	copy(record[5:], []byte{0xfe, 0xff, 0xff, 0xff, 0x0f})
+}
+
+// corruptRecord 用corrupt修改key所在记录在磁盘上的数据，返回记录所在的文件id
+func corruptRecord(t *testing.T, db *DB, key []byte, corrupt func(record []byte)) uint32 {
+	pos := db.index.Get(key)
+	file := db.olderFiles[pos.Fid]
+	if pos.Fid == db.activeFile.Fid {
+		file = db.activeFile
+	}
+	logicalSize, err := file.IOManager.Size()
+	assert.Nil(t, err)
+	fileName := data.GetDataFileName(db.options.DirPath, pos.Fid)
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	start := info.Size() - logicalSize + pos.Offset
+	corrupt(content[start : start+int64(pos.Size)])
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	return pos.Fid
+}
+
+func TestDB_Recovery_TornTail(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-recovery-torn-tail"
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	fileName := data.GetDataFileName(opts.DirPath, 1)
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	validSize := info.Size()
+
+	encoded, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   encodeKeyWithSeqNo(utils.GetTestKey(100), NonTransaction),
+		Value: utils.RandomValue(128),
+	})
+	// 只写了一半的记录；crc错误的记录；全0的数据
+	for _, tail := range [][]byte{encoded[:len(encoded)/2], append(encoded[:len(encoded)-1:len(encoded)-1], 0), make([]byte, 64)} {
+		appendToFile(t, fileName, tail)
+
+		// 只读模式下忽略，但不修改文件
+		roOpts := opts
+		roOpts.ReadOnly = true
+		reader, err := Open(roOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(100), reader.Stat().KeyNum)
+		assert.Nil(t, reader.Close())
+		info, err = os.Stat(fileName)
+		assert.Nil(t, err)
+		assert.Equal(t, validSize+int64(len(tail)), info.Size())
+
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(100), db.Stat().KeyNum)
+		info, err = os.Stat(fileName)
+		assert.Nil(t, err)
+		assert.Equal(t, validSize, info.Size())
+		assert.Nil(t, db.Close())
+	}
+
+	// 截断后新的写入紧接在最后一条完整的记录之后
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.GetTestKey(100)))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i <= 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestDB_Recovery_ActiveFileCorrupted(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-recovery-active-file"
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	corruptRecord(t, db, utils.GetTestKey(50), flipLastByte)
+	assert.Nil(t, db.Close())
+	fileName := data.GetDataFileName(opts.DirPath, 1)
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+
+	// 活跃文件中间的损坏不是没写完的记录，默认Open失败，后面完整的记录没有被截断
+	_, err = Open(opts)
+	assert.Equal(t, ErrInvalidCRC, err)
+	roOpts := opts
+	roOpts.ReadOnly = true
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrInvalidCRC, err)
+	info2, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), info2.Size())
+	report, err := Verify(opts.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, problemKinds(report)[ProblemCorruptedRecord])
+
+	// 允许跳过时才丢弃损坏处之后的数据
+	opts.RecoveryMode = RecoverySkipCorrupted
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(50), db.Stat().KeyNum)
+	info2, err = os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.True(t, info2.Size() < info.Size())
+}
+
+func TestDB_Recovery_SealedFile(t *testing.T) {
+	for name, corrupt := range map[string]func([]byte){"crc": flipLastByte, "key-size": corruptKeySize} {
+		t.Run(name, func(t *testing.T) {
+			testRecoverySealedFile(t, corrupt)
+		})
+	}
+
+	_, err := Open(Options{DirPath: "/tmp/kv/DB-recovery-invalid", RecoveryMode: 2})
+	assert.NotNil(t, err)
+}
+
+func testRecoverySealedFile(t *testing.T, corrupt func([]byte)) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-recovery-sealed-file"
+	opts.DataFileSize = 32 * 1024
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	fid := corruptRecord(t, db, utils.GetTestKey(100), corrupt)
+	assert.NotEqual(t, db.activeFile.Fid, fid)
+	assert.Nil(t, db.Close())
+
+	// 旧文件中间的损坏默认导致Open失败
+	_, err = Open(opts)
+	assert.True(t, isCorruption(err), err)
+	opts.IndexLoadWorkers = 4
+	_, err = Open(opts)
+	assert.True(t, isCorruption(err), err)
+
+	// 允许跳过时，损坏的记录及其所在文件之后的数据被丢弃，其他数据不受影响
+	opts.RecoveryMode = RecoverySkipCorrupted
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	lost := 0
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if err == ErrKeyNotFound {
+			assert.True(t, i >= 100)
+			lost++
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.True(t, lost > 0 && lost < 1000)
+
+	// merge不跳过损坏的数据，否则删除旧文件后这些数据就永久丢失了
+	err = db.Merge()
+	assert.True(t, isCorruption(err), err)
+	assert.Nil(t, db.Close())
+	_, err = Open(Options{DirPath: opts.DirPath, DataFileSize: opts.DataFileSize, IndexType: opts.IndexType})
+	assert.True(t, isCorruption(err), err)
+}
@@ -0,0 +1,98 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"sync"
+)
+
+// Snapshot 数据库在某一时刻的只读视图
+// 创建时冻结一份内存索引的副本，并引用（pin）当时所有的数据文件。
+// 由于数据文件只会追加写入，只要被引用的文件不被删除，通过冻结的索引读到的就始终是创建快照那一刻的数据。
+type Snapshot struct {
+	db       *DB
+	index    index.Indexer // 创建快照时冻结的索引
+	fids     []uint32      // 快照引用的数据文件
+	mu       *sync.RWMutex
+	released bool
+}
+
+// Snapshot 创建一个快照，使用完后必须调用Release释放
+// BTree索引是写时复制的，创建快照的代价与key的数量无关；ART、SkipList、Hash索引需要在持有写锁时复制整个索引，
+// 时间和内存都与key的数量成正比，期间所有读写都会被阻塞。B+树索引不支持快照
+func (db *DB) Snapshot() (*Snapshot, error) {
+	if _, ok := db.index.(index.Persistent); ok {
+		return nil, ErrSnapshotNotSupported
+	}
+	// 持有写锁，保证复制出来的索引不会包含写了一半的批量写入
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var fids []uint32
+	if db.activeFile != nil {
+		fids = append(fids, db.activeFile.Fid)
+	}
+	for fid := range db.olderFiles {
+		fids = append(fids, fid)
+	}
+	for _, fid := range fids {
+		db.filePins[fid]++
+	}
+
+	return &Snapshot{
+		db:    db,
+		index: index.Clone(db.index),
+		fids:  fids,
+		mu:    new(sync.RWMutex),
+	}, nil
+}
+
+// Get 读取快照中key对应的value
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	pos := s.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return s.db.getValueByPosition(pos)
+}
+
+// NewIterator 在快照上初始化迭代器，迭代器需要在Release之前关闭
+// 快照已经释放时返回一个没有数据的迭代器
+func (s *Snapshot) NewIterator(opts IteratorOptions) *IteratorUI {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return s.db.newIterator(index.NewBTree(), opts)
+	}
+	return s.db.newIterator(s.index, opts)
+}
+
+// Release 释放快照，解除对数据文件的引用。重复调用没有影响
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+
+	s.db.mu.Lock()
+	for _, fid := range s.fids {
+		if s.db.filePins[fid]--; s.db.filePins[fid] <= 0 {
+			delete(s.db.filePins, fid)
+		}
+	}
+	s.db.mu.Unlock()
+
+	_ = s.index.Close()
+}
@@ -0,0 +1,86 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-snapshot"
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	assert.Greater(t, len(db.filePins), 0)
+
+	// 快照之后的写入对快照不可见
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(99), []byte("new value"))
+	assert.Nil(t, err)
+
+	val, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	val, err = snap.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), val)
+	_, err = snap.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	var n int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		v, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), v)
+		n++
+	}
+	iter.Close()
+	assert.Equal(t, 100, n)
+
+	// 实时数据不受快照影响
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 释放之后不能再读，文件引用被解除
+	snap.Release()
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.Equal(t, 0, len(db.filePins))
+	iter = snap.NewIterator(DefaultIteratorOptions)
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestDB_Snapshot_BPTree(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-snapshot-bptree"
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.GetTestKey(1)))
+	snap, err := db.Snapshot()
+	assert.Nil(t, snap)
+	assert.Equal(t, ErrSnapshotNotSupported, err)
+	assert.Equal(t, 0, len(db.filePins))
+}
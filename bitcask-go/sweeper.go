@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// expireSweeper 后台过期key清理的状态
+// 每一轮从上一轮停下的位置（cursor）继续遍历内存索引，遍历到末尾后从头开始，
+// 这样每轮只需要检查一小段key，不会长时间占用锁
+type expireSweeper struct {
+	cursor []byte // 下一轮开始检查的key，nil表示从头开始
+	cycles uint64 // 已运行的轮数
+	swept  uint64 // 已清理掉的过期key数量
+}
+
+// newSweepTicker 创建触发每一轮过期key清理的时钟，返回时钟以及停止它的函数。测试中替换它来控制清理的时机
+var newSweepTicker = func(interval time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// runExpireSweeper 按配置的间隔周期性地清理过期key，直到db关闭
+func (db *DB) runExpireSweeper() {
+	defer db.bgWorkers.Done()
+
+	tick, stop := newSweepTicker(db.options.ExpireSweepInterval)
+	defer stop()
+	for {
+		select {
+		case <-db.closed:
+			return
+		case <-tick:
+			if _, err := db.sweepExpiredKeys(); err != nil {
+				log.Printf("failed to sweep expired keys: %v", err)
+			}
+		}
+	}
+}
+
+// sweepExpiredKeys 运行一轮过期key清理，返回本轮清理掉的key数量
+// S1:从cursor开始遍历内存索引，最多检查ExpireSweepMaxKeys个key，找出其中已过期的key
+// S2:为过期的key写入墓碑值并从内存索引中删除，整轮不超过ExpireSweepMaxDuration
+func (db *DB) sweepExpiredKeys() (int, error) {
+	start := time.Now()
+	maxKeys, maxDuration := db.options.ExpireSweepMaxKeys, db.options.ExpireSweepMaxDuration
+	outOfTime := func() bool {
+		return maxDuration > 0 && time.Since(start) >= maxDuration
+	}
+	atomic.AddUint64(&db.sweeper.cycles, 1)
+
+	// S1
+	iter := db.index.Iterator(false)
+	if db.sweeper.cursor == nil {
+		iter.Rewind()
+	} else {
+		iter.Seek(db.sweeper.cursor)
+	}
+	var expiredKeys [][]byte
+	for checked := 0; iter.IsValid(); iter.Next() {
+		if (maxKeys > 0 && checked >= maxKeys) || outOfTime() {
+			break
+		}
+		if iter.Value().IsExpired() {
+			expiredKeys = append(expiredKeys, iter.Key())
+		}
+		checked++
+	}
+	// 记录下一轮开始的位置，遍历到末尾则下一轮从头开始
+	if iter.IsValid() {
+		db.sweeper.cursor = iter.Key()
+	} else {
+		db.sweeper.cursor = nil
+	}
+	iter.Close()
+
+	if len(expiredKeys) == 0 {
+		return 0, nil
+	}
+
+	// S2
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var swept int
+	for _, key := range expiredKeys {
+		if outOfTime() {
+			break
+		}
+		// 遍历时没有持有锁，key可能已经被重新写入，需要再检查一次
+		if pos := db.index.Get(key); pos == nil || !pos.IsExpired() {
+			continue
+		}
+		if err := db.deleteWithoutLock(key); err != nil {
+			return swept, err
+		}
+		swept++
+		atomic.AddUint64(&db.sweeper.swept, 1)
+	}
+	return swept, nil
+}
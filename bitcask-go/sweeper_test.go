@@ -0,0 +1,84 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// putExpired 直接写入一个已经过期的key，不需要等待它过期
+func putExpired(t *testing.T, db *DB, key []byte, value []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Nil(t, db.putWithoutLock(key, value, time.Now().Add(-time.Second).UnixNano()))
+}
+
+func TestDB_SweepExpiredKeys(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-sweeper"
+	opts.ExpireSweepMaxKeys = 10
+	opts.ExpireSweepMaxDuration = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 25; i++ {
+		putExpired(t, db, utils.GetTestKey(i), utils.RandomValue(10))
+	}
+	for i := 25; i < 30; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	// 每轮最多检查10个key，需要多轮才能全部清理完
+	swept, err := db.sweepExpiredKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, swept)
+	for i := 0; i < 3; i++ {
+		_, err = db.sweepExpiredKeys()
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 5, db.index.Size())
+
+	stat := db.Stat()
+	assert.Equal(t, uint64(4), stat.ExpireSweepCycles)
+	assert.Equal(t, uint64(25), stat.ExpiredKeysSwept)
+	assert.Equal(t, uint(5), stat.KeyNum)
+}
+
+func TestDB_ExpireSweeperInBackground(t *testing.T) {
+	// 由测试控制每一轮清理的时机
+	tick := make(chan time.Time)
+	stopped := false
+	defer func(old func(time.Duration) (<-chan time.Time, func())) { newSweepTicker = old }(newSweepTicker)
+	newSweepTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return tick, func() { stopped = true }
+	}
+
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-sweeper"
+	opts.ExpireSweepInterval = time.Hour
+	opts.ExpireSweepMaxKeys = 0
+	opts.ExpireSweepMaxDuration = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		putExpired(t, db, utils.GetTestKey(i), utils.RandomValue(10))
+	}
+	// 第二次发送要等第一轮清理结束后才会被接收
+	tick <- time.Now()
+	tick <- time.Now()
+	stat := db.Stat()
+	assert.Equal(t, uint(0), stat.KeyNum)
+	assert.Equal(t, uint64(100), stat.ExpiredKeysSwept)
+
+	// 关闭时后台goroutine能正常退出
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.True(t, stopped)
+}
@@ -0,0 +1,119 @@
+package bitcask_go
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// resolveSyncPolicy 没有设置SyncPolicy时，按照SyncWrites和SyncPerBytes得到对应的持久化策略
+func resolveSyncPolicy(options Options) SyncPolicy {
+	if options.SyncPolicy.Mode != SyncDefault {
+		return options.SyncPolicy
+	}
+	if options.SyncWrites {
+		return SyncPolicy{Mode: SyncAlways}
+	}
+	if options.SyncPerBytes > 0 {
+		return SyncPolicy{Mode: SyncEveryNBytes, Bytes: options.SyncPerBytes}
+	}
+	return SyncPolicy{Mode: SyncNever}
+}
+
+func checkSyncPolicy(policy SyncPolicy) error {
+	switch policy.Mode {
+	case SyncDefault, SyncAlways, SyncNever:
+	case SyncEveryNBytes:
+		if policy.Bytes == 0 {
+			return errors.New("sync bytes should > 0")
+		}
+	case SyncInterval:
+		if policy.Interval <= 0 {
+			return errors.New("sync interval should > 0")
+		}
+	default:
+		return errors.New("unknown sync mode")
+	}
+	return nil
+}
+
+// syncOnWrite 每次写入后是否都需要持久化
+func (db *DB) syncOnWrite() bool {
+	return db.options.SyncPolicy.Mode == SyncAlways
+}
+
+// LastSyncedOffset 返回已经持久化到的位置：id小于fid的数据文件，以及数据文件fid中offset之前的数据，
+// 在系统崩溃后都不会丢失
+func (db *DB) LastSyncedOffset() (fid uint32, offset int64) {
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+	return db.syncedFid, db.syncedOffset
+}
+
+// markSynced 记录已经持久化到的位置，只会向前推进
+func (db *DB) markSynced(fid uint32, offset int64) {
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+	if fid > db.syncedFid || (fid == db.syncedFid && offset > db.syncedOffset) {
+		db.syncedFid, db.syncedOffset = fid, offset
+	}
+}
+
+// syncActiveFileWithoutLock 持久化当前活跃文件，并记录已经持久化到的位置
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) syncActiveFileWithoutLock() error {
+	if err := db.activeFile.SyncFile(); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	db.markSynced(db.activeFile.Fid, db.activeFile.WriteOffset)
+	return nil
+}
+
+// runIntervalSyncer 按SyncPolicy中配置的间隔在后台持久化活跃文件，直到db关闭
+func (db *DB) runIntervalSyncer() {
+	defer db.bgWorkers.Done()
+
+	ticker := time.NewTicker(db.options.SyncPolicy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closed:
+			return
+		case <-ticker.C:
+			if err := db.syncInBackground(); err != nil {
+				log.Printf("failed to sync the active file: %v", err)
+			}
+		}
+	}
+}
+
+// syncInBackground 持久化活跃文件。fsync时不持有db锁，不会阻塞写入
+func (db *DB) syncInBackground() error {
+	db.mu.RLock()
+	file := db.activeFile
+	var offset int64
+	if file != nil {
+		offset = file.WriteOffset
+	}
+	db.mu.RUnlock()
+	if file == nil {
+		return nil
+	}
+	if fid, synced := db.LastSyncedOffset(); fid > file.Fid || (fid == file.Fid && synced >= offset) {
+		return nil
+	}
+
+	if err := file.SyncFile(); err != nil {
+		// 期间活跃文件已经写满，它在变成旧文件时已经持久化过了（之后可能被merge关闭）
+		db.mu.RLock()
+		active := db.activeFile == file
+		db.mu.RUnlock()
+		if !active {
+			return nil
+		}
+		return err
+	}
+	db.markSynced(file.Fid, offset)
+	return nil
+}
@@ -0,0 +1,143 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// activeOffset 当前活跃文件写到的位置
+func activeOffset(db *DB) (uint32, int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.activeFile.Fid, db.activeFile.WriteOffset
+}
+
+func TestResolveSyncPolicy(t *testing.T) {
+	opts := DefaultOptions
+	assert.Equal(t, SyncPolicy{Mode: SyncNever}, resolveSyncPolicy(opts))
+	opts.SyncPerBytes = 4096
+	assert.Equal(t, SyncPolicy{Mode: SyncEveryNBytes, Bytes: 4096}, resolveSyncPolicy(opts))
+	opts.SyncWrites = true
+	assert.Equal(t, SyncPolicy{Mode: SyncAlways}, resolveSyncPolicy(opts))
+	// 设置了SyncPolicy时以它为准
+	opts.SyncPolicy = SyncPolicy{Mode: SyncInterval, Interval: time.Second}
+	assert.Equal(t, opts.SyncPolicy, resolveSyncPolicy(opts))
+
+	for _, policy := range []SyncPolicy{
+		{Mode: SyncEveryNBytes},
+		{Mode: SyncInterval},
+		{Mode: SyncInterval, Interval: -time.Second},
+		{Mode: 100},
+	} {
+		opts := DefaultOptions
+		opts.DirPath = "/tmp/kv/DB-sync-policy-invalid"
+		opts.SyncPolicy = policy
+		_, err := Open(opts)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestDB_SyncPolicy_Always(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-sync-policy-always"
+	opts.SyncPolicy = SyncPolicy{Mode: SyncAlways}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		fid, offset := db.LastSyncedOffset()
+		activeFid, activeOff := activeOffset(db)
+		assert.Equal(t, activeFid, fid)
+		assert.Equal(t, activeOff, offset)
+	}
+}
+
+func TestDB_SyncPolicy_EveryNBytes(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-sync-policy-every-n-bytes"
+	opts.DataFileSize = 64 * 1024
+	opts.SyncPolicy = SyncPolicy{Mode: SyncEveryNBytes, Bytes: 4096}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		// 未持久化的数据不超过配置的字节数
+		fid, offset := db.LastSyncedOffset()
+		activeFid, activeOff := activeOffset(db)
+		if fid == activeFid {
+			assert.True(t, activeOff-offset < 4096)
+		} else {
+			assert.Equal(t, activeFid-1, fid)
+			assert.True(t, activeOff < 4096)
+		}
+	}
+	fid, _ := db.LastSyncedOffset()
+	assert.True(t, fid > 1)
+}
+
+func TestDB_SyncPolicy_Interval(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-sync-policy-interval"
+	opts.SyncPolicy = SyncPolicy{Mode: SyncInterval, Interval: 10 * time.Millisecond}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	// 后台持久化后，已经持久化到活跃文件末尾
+	activeFid, activeOff := activeOffset(db)
+	assert.Eventually(t, func() bool {
+		fid, offset := db.LastSyncedOffset()
+		return fid == activeFid && offset == activeOff
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestDB_SyncPolicy_Never(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-sync-policy-never"
+	opts.DataFileSize = 64 * 1024
+	opts.SyncPolicy = SyncPolicy{Mode: SyncNever}
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	fid, offset := db.LastSyncedOffset()
+	assert.Equal(t, uint32(0), fid)
+	assert.Equal(t, int64(0), offset)
+
+	// 活跃文件写满时会持久化
+	for i := 100; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	fid, _ = db.LastSyncedOffset()
+	assert.True(t, fid >= 1)
+
+	// 手动持久化
+	assert.Nil(t, db.Sync())
+	fid, offset = db.LastSyncedOffset()
+	activeFid, activeOff := activeOffset(db)
+	assert.Equal(t, activeFid, fid)
+	assert.Equal(t, activeOff, offset)
+
+	// 重新打开时，之前写入的数据都已经持久化
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(128)))
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	fid, offset = db.LastSyncedOffset()
+	activeFid, activeOff = activeOffset(db)
+	assert.Equal(t, activeFid, fid)
+	assert.Equal(t, activeOff, offset)
+}
@@ -0,0 +1,206 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"github.com/google/btree"
+	"time"
+)
+
+/*
+	ttl.go 为键值对提供过期时间（TTL）支持。
+	过期时间保存在LogRecord的header中，同时保存在内存索引的LogRecordPos里，
+	Get、迭代器、ListKeys和Stat都会跳过已过期的key，merge时过期的记录会被清理掉。
+	设置了过期时间的key另外按过期时间排序保存一份（DB.expiring），Stat统计key的数量时只需要数出其中已过期的部分。
+*/
+
+// PutWithTTL 写入一个键值对，并在ttl之后过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putWithoutLock(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// Expire 为已存在的key设置新的过期时间。ttl <= 0 时直接删除该key（与redis的EXPIRE一致）
+// 实现方式是把当前的value连同新的过期时间重新追加写入一次
+func (db *DB) Expire(key []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.getLiveValueWithoutLock(key)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return db.deleteWithoutLock(key)
+	}
+	return db.putWithoutLock(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// TTL 返回key的剩余存活时间。key永不过期时返回 -1，key不存在或已过期时返回ErrKeyNotFound
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return 0, ErrKeyNotFound
+	}
+	if pos.Expire == 0 {
+		return -1, nil
+	}
+	return time.Duration(pos.Expire - time.Now().UnixNano()), nil
+}
+
+// Persist 移除key的过期时间，使其永不过期
+func (db *DB) Persist(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.getLiveValueWithoutLock(key)
+	if err != nil {
+		return err
+	}
+	// 本来就没有过期时间，不需要重写
+	if db.index.Get(key).Expire == 0 {
+		return nil
+	}
+	return db.putWithoutLock(key, value, 0)
+}
+
+// getLiveValueWithoutLock 读取一个未过期的key的value
+// *************** 访问此方法前必须持有锁 ******************
+func (db *DB) getLiveValueWithoutLock(key []byte) ([]byte, error) {
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return db.getValueByPosition(pos)
+}
+
+// removeExpiredKeysWithoutLock 将已过期的key从内存索引中移除，并把它们计入invalidSize
+// 过期的记录不需要再写墓碑值：重启加载索引时它们依然是过期的，会被直接跳过
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) removeExpiredKeysWithoutLock() error {
+	var expiredKeys [][]byte
+	db.ascendExpired(func(key string) {
+		expiredKeys = append(expiredKeys, []byte(key))
+	})
+
+	if len(expiredKeys) == 0 {
+		return nil
+	}
+	return db.updateIndex(func(idx index.Writer) error {
+		for _, key := range expiredKeys {
+			if oldPos, ok := idx.Delete(key); ok && oldPos != nil {
+				db.addInvalidSize(oldPos)
+			}
+		}
+		return nil
+	})
+}
+
+// expiringKey 设置了过期时间的key，先按过期时间、再按key排序
+type expiringKey struct {
+	expire int64
+	key    string
+}
+
+func newExpiringKeys() *btree.BTreeG[expiringKey] {
+	return btree.NewG(32, func(a, b expiringKey) bool {
+		if a.expire != b.expire {
+			return a.expire < b.expire
+		}
+		return a.key < b.key
+	})
+}
+
+// loadExpiringKeys 索引加载完成后，找出其中设置了过期时间的key
+// 之后索引的修改都经过updateIndex，由expiringWriter同步更新
+func (db *DB) loadExpiringKeys() {
+	db.expiring.Clear(false)
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.IsValid(); iter.Next() {
+		if expire := iter.Value().Expire; expire != 0 {
+			db.expiring.ReplaceOrInsert(expiringKey{expire: expire, key: string(iter.Key())})
+		}
+	}
+}
+
+// ascendExpired 按过期时间从早到晚遍历已经过期的key，遇到第一个还没过期的key就停下
+// *************** 访问此方法前必须持有锁 ******************
+func (db *DB) ascendExpired(fn func(key string)) {
+	now := time.Now().UnixNano()
+	db.expiring.Ascend(func(item expiringKey) bool {
+		if item.expire > now {
+			return false
+		}
+		fn(item.key)
+		return true
+	})
+}
+
+// expiringWriter 记录一次索引修改中key的过期时间的变化，修改生效后再更新到DB的expiring中
+type expiringWriter struct {
+	index.Writer
+	changes []expireChange
+}
+
+type expireChange struct {
+	key        string
+	old, value int64
+}
+
+func (w *expiringWriter) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldPos := w.Writer.Put(key, pos)
+	w.record(key, oldPos, pos)
+	return oldPos
+}
+
+func (w *expiringWriter) Delete(key []byte) (*data.LogRecordPos, bool) {
+	oldPos, ok := w.Writer.Delete(key)
+	w.record(key, oldPos, nil)
+	return oldPos, ok
+}
+
+func (w *expiringWriter) record(key []byte, oldPos, pos *data.LogRecordPos) {
+	var change expireChange
+	if oldPos != nil {
+		change.old = oldPos.Expire
+	}
+	if pos != nil {
+		change.value = pos.Expire
+	}
+	if change.old != change.value {
+		change.key = string(key)
+		w.changes = append(w.changes, change)
+	}
+}
+
+func (w *expiringWriter) apply(expiring *btree.BTreeG[expiringKey]) {
+	for _, change := range w.changes {
+		if change.old != 0 {
+			expiring.Delete(expiringKey{expire: change.old, key: change.key})
+		}
+		if change.value != 0 {
+			expiring.ReplaceOrInsert(expiringKey{expire: change.value, key: change.key})
+		}
+	}
+	w.changes = nil
+}
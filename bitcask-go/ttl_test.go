@@ -0,0 +1,193 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-ttl"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// ttl 不合法
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 0)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// 还没过期可以读到
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	// 过期之后读不到，迭代器、ListKeys、Stat都不再包含它
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var n int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(2), iter.Key())
+		n++
+	}
+	iter.Close()
+	assert.Equal(t, 1, n)
+
+	// 重启之后过期的key也不会被加载，并且计入无效数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Greater(t, db2.invalidSize, int64(0))
+}
+
+func TestDB_ExpireAndPersist(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-ttl"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 不存在的key
+	err = db.Expire(utils.GetTestKey(1), time.Second)
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 没有过期时间的key
+	val := utils.RandomValue(10)
+	err = db.Put(utils.GetTestKey(1), val)
+	assert.Nil(t, err)
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// 设置过期时间
+	err = db.Expire(utils.GetTestKey(1), time.Hour)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+	val2, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val, val2)
+
+	// 移除过期时间，重启后依然有效
+	err = db.Persist(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	ttl, err = db2.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// ttl <= 0 时直接删除
+	err = db2.Expire(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// scanKeyNum 遍历整个索引统计未过期的key的数量，用来和Stat的结果对比
+func scanKeyNum(db *DB) uint {
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	var n uint
+	for iter.Rewind(); iter.IsValid(); iter.Next() {
+		if !iter.Value().IsExpired() {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDB_StatKeyNum(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, BPTree} {
+		t.Run(fmt.Sprintf("index-%d", indexType), func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = "/tmp/kv/DB-ttl-keynum"
+			opts.DataFileSize = 256
+			opts.MergeRatioThreshold = 0
+			opts.IndexType = indexType
+			db, err := Open(opts)
+			defer func() { destroyDB(db) }()
+			assert.Nil(t, err)
+
+			check := func(expected uint) {
+				assert.Equal(t, expected, db.Stat().KeyNum)
+				assert.Equal(t, expected, scanKeyNum(db))
+			}
+			for i := 0; i < 10; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+			}
+			for i := 10; i < 20; i++ {
+				assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(10), time.Hour))
+			}
+			for i := 20; i < 25; i++ {
+				putExpired(t, db, utils.GetTestKey(i), utils.RandomValue(10))
+			}
+			check(20)
+
+			// 覆盖写、删除、移除过期时间、CAS、WriteBatch之后依然准确
+			putExpired(t, db, utils.GetTestKey(0), utils.RandomValue(10))
+			assert.Nil(t, db.Delete(utils.GetTestKey(10)))
+			assert.Nil(t, db.Persist(utils.GetTestKey(11)))
+			assert.Nil(t, db.Put(utils.GetTestKey(20), utils.RandomValue(10)))
+			check(19)
+			old, err := db.Get(utils.GetTestKey(12))
+			assert.Nil(t, err)
+			ok, err := db.CompareAndSwap(utils.GetTestKey(12), old, utils.RandomValue(10))
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.PendingDelete(utils.GetTestKey(13)))
+			assert.Nil(t, wb.PendingPut(utils.GetTestKey(21), utils.RandomValue(10)))
+			assert.Nil(t, wb.Commit())
+			check(19)
+			assert.Equal(t, db.index.Size(), db.expiring.Len()+scanKeyNumWithoutTTL(db))
+
+			// merge清理掉过期的key，重启之后重新统计
+			assert.Nil(t, db.Merge())
+			check(19)
+			assert.Equal(t, 7, db.expiring.Len())
+			assert.Nil(t, db.Close())
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			check(19)
+			assert.Equal(t, 7, db.expiring.Len())
+		})
+	}
+}
+
+// scanKeyNumWithoutTTL 遍历整个索引统计没有过期时间的key的数量
+func scanKeyNumWithoutTTL(db *DB) int {
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	var n int
+	for iter.Rewind(); iter.IsValid(); iter.Next() {
+		if iter.Value().Expire == 0 {
+			n++
+		}
+	}
+	return n
+}
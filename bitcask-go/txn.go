@@ -0,0 +1,287 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+/*
+	txn.go 交互式的乐观事务。
+	与WriteBatch一样，事务中的写入先暂存在内存里，提交时以同一个事务序列号批量写入；
+	不同的是事务中的Get可以读到自己暂存的写入，并且提交时会检查读过的key在事务开始之后是否被其他写入修改过。
+
+	冲突检测：Begin时将db.seqNo加1作为事务的起始序列号。有事务未结束时，每次写入都会在txnVersions中记下
+	被修改的key和一个大于所有未结束事务起始序列号的版本号（事务提交时为本次的事务序列号，普通写入为seqNo+1）。
+	提交时只要读过的某个key的版本号大于起始序列号，就说明它在事务开始之后被修改过。
+	每个事务结束时，删掉不大于最早的未结束事务起始序列号的版本记录，它们不会再和任何事务冲突。
+	没有Commit或Rollback就被丢弃的事务，在被垃圾回收时自动回滚，不会一直阻止版本记录被清理。
+*/
+
+// Txn 交互式事务，通过DB.Begin创建，必须以Commit或Rollback结束
+type Txn struct {
+	db            *DB
+	mu            *sync.Mutex
+	startSeq      uint64                     // 事务开始时的序列号
+	pendingWrites map[string]*data.LogRecord // 暂存事务中的写入
+	reads         map[string]struct{}        // 事务中读过的key，提交时检查冲突
+	finished      bool
+}
+
+// Begin 开启一个事务
+func (db *DB) Begin() *Txn {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.seqNo++
+	db.activeTxns[db.seqNo] = struct{}{}
+	txn := &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		startSeq:      db.seqNo,
+		pendingWrites: make(map[string]*data.LogRecord),
+		reads:         make(map[string]struct{}),
+	}
+	runtime.SetFinalizer(txn, func(txn *Txn) {
+		_ = txn.Rollback()
+	})
+	return txn
+}
+
+// Get 读取key对应的value，优先返回事务中暂存的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+	txn.reads[string(key)] = struct{}{}
+	return txn.db.Get(key)
+}
+
+// Put 在事务中写入键值对
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  data.LogRecordNormal,
+	}
+	return nil
+}
+
+// Delete 在事务中删除key
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// Iterate 按照opts遍历事务可见的所有数据（数据库中的数据叠加上事务中暂存的写入），fn返回false时终止遍历
+// 遍历到的key都会计入读集合
+func (txn *Txn) Iterate(opts IteratorOptions, fn func(key []byte, value []byte) bool) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	// 事务中暂存的key，按照遍历的方向排序
+	var pendingKeys [][]byte
+	for _, record := range txn.pendingWrites {
+		if opts.contains(record.Key) {
+			pendingKeys = append(pendingKeys, record.Key)
+		}
+	}
+	less := func(a, b []byte) bool {
+		if opts.Reverse {
+			return bytes.Compare(a, b) > 0
+		}
+		return bytes.Compare(a, b) < 0
+	}
+	sort.Slice(pendingKeys, func(i, j int) bool {
+		return less(pendingKeys[i], pendingKeys[j])
+	})
+
+	// Limit对归并后的结果生效
+	limit := opts.Limit
+	opts.Limit = 0
+	var visited int
+	visit := func(key []byte, value []byte) bool {
+		visited++
+		return fn(key, value) && (limit == 0 || visited < limit)
+	}
+
+	// 暂存的写入：删除的key直接跳过
+	visitPending := func(key []byte) bool {
+		record := txn.pendingWrites[string(key)]
+		if record.Type == data.LogRecordDeleted {
+			return true
+		}
+		return visit(key, record.Value)
+	}
+
+	// 归并数据库迭代器和暂存的key，key相同时以暂存的写入为准
+	iter := txn.db.NewIterator(opts)
+	defer iter.Close()
+	var i int
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		for ; i < len(pendingKeys) && less(pendingKeys[i], key); i++ {
+			if !visitPending(pendingKeys[i]) {
+				return nil
+			}
+		}
+		if i < len(pendingKeys) && bytes.Equal(pendingKeys[i], key) {
+			if !visitPending(pendingKeys[i]) {
+				return nil
+			}
+			i++
+			continue
+		}
+
+		txn.reads[string(key)] = struct{}{}
+		value, err := iter.Value()
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !visit(key, value) {
+			return nil
+		}
+	}
+	for ; i < len(pendingKeys); i++ {
+		if !visitPending(pendingKeys[i]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Commit 提交事务。如果事务读过的key在事务开始后被修改过，返回ErrTxnConflict，事务中的写入全部丢弃
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	db := txn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	defer txn.finishWithoutLock()
+
+	// 只读事务不需要检查冲突
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	// 冲突检测
+	for key := range txn.reads {
+		if version, ok := db.txnVersions[key]; ok && version > txn.startSeq {
+			return ErrTxnConflict
+		}
+	}
+
+	records := make([]*data.LogRecord, 0, len(txn.pendingWrites))
+	for _, record := range txn.pendingWrites {
+		// 删除一个本来就不存在的key，不需要写入
+		if record.Type == data.LogRecordDeleted && db.index.Get(record.Key) == nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return db.writeTransactionWithoutLock(records, db.syncOnWrite())
+}
+
+// Rollback 回滚事务，丢弃事务中暂存的所有写入
+func (txn *Txn) Rollback() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+	txn.finishWithoutLock()
+	return nil
+}
+
+// finishWithoutLock 结束事务，并清理不再需要的版本记录
+// *************** 访问此方法前必须持有db的互斥锁 ******************
+func (txn *Txn) finishWithoutLock() {
+	txn.finished = true
+	txn.pendingWrites = nil
+	txn.reads = nil
+	runtime.SetFinalizer(txn, nil)
+
+	delete(txn.db.activeTxns, txn.startSeq)
+	txn.db.pruneTxnVersionsWithoutLock()
+}
+
+// pruneTxnVersionsWithoutLock 删掉不大于最早的未结束事务起始序列号的版本记录。没有未结束的事务时，清空版本记录
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) pruneTxnVersionsWithoutLock() {
+	if len(db.activeTxns) == 0 {
+		db.txnVersions = make(map[string]uint64)
+		return
+	}
+	var oldest uint64 = math.MaxUint64
+	for startSeq := range db.activeTxns {
+		if startSeq < oldest {
+			oldest = startSeq
+		}
+	}
+	for key, version := range db.txnVersions {
+		if version <= oldest {
+			delete(db.txnVersions, key)
+		}
+	}
+}
+
+// markModifiedWithoutLock 有事务未结束时，记录key被修改时的版本号
+// *************** 访问此方法前必须持有互斥锁 ******************
+func (db *DB) markModifiedWithoutLock(key []byte, version uint64) {
+	if len(db.activeTxns) == 0 {
+		return
+	}
+	db.txnVersions[string(key)] = version
+}
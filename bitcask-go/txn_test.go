@@ -0,0 +1,152 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTxn_ReadYourWrites(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-txn"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	err = txn.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 事务内可以读到自己的写入，事务外读不到
+	val, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var keys [][]byte
+	err = txn.Iterate(DefaultIteratorOptions, func(key []byte, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(2)}, keys)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 提交之后写入生效，重启后依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-txn"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+
+	// 事务开始之前的写入不算冲突
+	txn1 := db.Begin()
+	txn2 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// txn1 先提交成功，txn2 读过的key被修改，提交失败
+	err = txn1.Put(utils.GetTestKey(1), []byte("2"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(1), []byte("3"))
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 普通写入也会导致冲突
+	txn3 := db.Begin()
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), []byte("4"))
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(2), []byte("x"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 回滚后写入被丢弃
+	txn4 := db.Begin()
+	err = txn4.Put(utils.GetTestKey(3), []byte("y"))
+	assert.Nil(t, err)
+	err = txn4.Rollback()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, len(db.activeTxns))
+	assert.Equal(t, 0, len(db.txnVersions))
+}
+
+func TestTxn_PruneVersions(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/DB-txn-prune"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// txn1结束后，txn2开始之前的修改不会再和任何事务冲突，版本记录被删掉
+	txn1 := db.Begin()
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("1")))
+	db.Begin() // 没有结束就被丢弃的事务
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("2")))
+	assert.Equal(t, 2, len(db.txnVersions))
+	assert.Nil(t, txn1.Rollback())
+	assert.Equal(t, 1, len(db.txnVersions))
+	_, ok := db.txnVersions[string(utils.GetTestKey(2))]
+	assert.True(t, ok)
+
+	// 丢弃的事务被垃圾回收时自动回滚
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		db.mu.RLock()
+		n := len(db.activeTxns)
+		db.mu.RUnlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	db.mu.RLock()
+	assert.Equal(t, 0, len(db.activeTxns))
+	assert.Equal(t, 0, len(db.txnVersions))
+	db.mu.RUnlock()
+}
@@ -0,0 +1,460 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VerifyOptions 离线检查数据目录的配置
+type VerifyOptions struct {
+	// 数据文件加密时用来解密的密钥来源
+	Encryption KeyProvider
+
+	// 不为空时，把所有能读出的有效记录按原来的文件id重写到这个目录（必须不存在或者为空），得到一份修复后的数据目录
+	// 损坏处之后的记录、没有提交完成的事务记录不会写入；hint文件和索引文件不复制，打开时重新生成
+	RepairDir string
+}
+
+// ProblemKind 检查出的问题的种类
+type ProblemKind = string
+
+const (
+	// ProblemFileHeader 文件头损坏、版本不支持或者无法解密，整个文件都无法读取
+	ProblemFileHeader ProblemKind = "file-header"
+	// ProblemCorruptedRecord 旧数据文件中有损坏的记录，之后的数据都无法读取，Open默认会失败
+	ProblemCorruptedRecord ProblemKind = "corrupted-record"
+	// ProblemTornTail 活跃文件末尾有没写完的记录，Open时会被截断
+	ProblemTornTail ProblemKind = "torn-tail"
+	// ProblemHintMismatch hint文件中的记录与数据文件不一致
+	ProblemHintMismatch ProblemKind = "hint-mismatch"
+	// ProblemOrphanTransaction 事务的记录没有对应的TransactionFinished标识，加载时会被丢弃
+	ProblemOrphanTransaction ProblemKind = "orphan-transaction"
+	// ProblemStaleMerge 上次merge留下的merge目录或者增量merge的临时文件
+	ProblemStaleMerge ProblemKind = "stale-merge"
+)
+
+// VerifyProblem 检查出的一个问题
+type VerifyProblem struct {
+	Kind    ProblemKind `json:"kind"`
+	File    string      `json:"file"`
+	Offset  int64       `json:"offset"` // 问题所在的位置（不包含文件头），与具体位置无关时为-1
+	Message string      `json:"message"`
+}
+
+// VerifiedFile 一个数据文件的检查结果
+type VerifiedFile struct {
+	Fid        uint32 `json:"fid"`
+	Version    uint16 `json:"version"`
+	Encrypted  bool   `json:"encrypted"`
+	Size       int64  `json:"size"`        // 不包含文件头
+	ValidSize  int64  `json:"valid_size"`  // 能完整读出的记录的长度
+	Records    int    `json:"records"`     // 能完整读出的记录数
+	HasHint    bool   `json:"has_hint"`    // 是否有对应的hint文件
+	HintPassed bool   `json:"hint_passed"` // hint文件是否与数据文件一致
+}
+
+// VerifyReport 数据目录的检查结果
+type VerifyReport struct {
+	Dir             string          `json:"dir"`
+	Files           []VerifiedFile  `json:"files"`
+	Problems        []VerifyProblem `json:"problems"`
+	RepairDir       string          `json:"repair_dir,omitempty"`
+	RepairedRecords int             `json:"repaired_records,omitempty"`
+}
+
+// OK 没有检查出任何问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) addProblem(kind ProblemKind, file string, offset int64, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, VerifyProblem{Kind: kind, File: file, Offset: offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// Verify 不打开数据库，检查dir目录下的数据文件和hint文件，以及上次merge的残留
+// 检查过程只读取文件，不加文件锁，不修改数据目录
+func Verify(dir string) (*VerifyReport, error) {
+	return VerifyWithOptions(dir, VerifyOptions{})
+}
+
+// VerifyWithOptions 与Verify相同，可以指定解密用的密钥，以及把修复后的数据写到另一个目录
+func VerifyWithOptions(dir string, opts VerifyOptions) (*VerifyReport, error) {
+	v := &verifier{
+		dir:    dir,
+		opts:   opts,
+		report: &VerifyReport{Dir: dir, Files: []VerifiedFile{}, Problems: []VerifyProblem{}},
+		files:  make(map[uint32]*data.File),
+	}
+	defer v.close()
+
+	fids, err := v.listDataFiles()
+	if err != nil {
+		return nil, err
+	}
+	txns := make(map[uint64]*orphanTxn)
+	for i, fid := range fids {
+		if err = v.verifyDataFile(fid, i == len(fids)-1, txns); err != nil {
+			return nil, err
+		}
+	}
+	orphans := v.reportOrphanTxns(txns)
+	if err = v.verifyMergeHint(); err != nil {
+		return nil, err
+	}
+	if err = v.verifyStaleMerge(); err != nil {
+		return nil, err
+	}
+
+	if opts.RepairDir != "" {
+		if err = v.repair(fids, orphans); err != nil {
+			return nil, err
+		}
+	}
+	return v.report, nil
+}
+
+// orphanTxn 还没有读到TransactionFinished标识的事务
+type orphanTxn struct {
+	records int
+	fid     uint32
+	offset  int64
+}
+
+type verifier struct {
+	dir    string
+	opts   VerifyOptions
+	report *VerifyReport
+	files  map[uint32]*data.File // 成功打开的数据文件
+}
+
+func (v *verifier) close() {
+	for _, file := range v.files {
+		_ = file.Close()
+	}
+}
+
+func (v *verifier) listDataFiles() ([]uint32, error) {
+	entries, err := os.ReadDir(v.dir)
+	if err != nil {
+		return nil, err
+	}
+	var fids []uint32
+	dataFids := make(map[uint32]bool)
+	var hintFids []uint32
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, compactFileSuffix):
+			v.report.addProblem(ProblemStaleMerge, name, -1, "temporary file left by an unfinished incremental merge")
+		case strings.HasSuffix(name, data.FileSuffix), strings.HasSuffix(name, data.HintFileSuffix):
+			fid, err1 := strconv.ParseUint(strings.Split(name, ".")[0], 10, 32)
+			if err1 != nil {
+				return nil, ErrDataFileDirectoryCorrupted
+			}
+			if strings.HasSuffix(name, data.FileSuffix) {
+				fids = append(fids, uint32(fid))
+				dataFids[uint32(fid)] = true
+			} else {
+				hintFids = append(hintFids, uint32(fid))
+			}
+		}
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fids[i] < fids[j]
+	})
+	for _, fid := range hintFids {
+		if !dataFids[fid] {
+			v.report.addProblem(ProblemHintMismatch, filepath.Base(data.GetDataFileHintName(v.dir, fid)), -1,
+				"hint file without its data file")
+		}
+	}
+	return fids, nil
+}
+
+// verifyDataFile 依次读出数据文件中的每一条记录，校验crc，再检查它的hint文件
+func (v *verifier) verifyDataFile(fid uint32, isActive bool, txns map[uint64]*orphanTxn) error {
+	fileName := data.GetDataFileName(v.dir, fid)
+	name := filepath.Base(fileName)
+	dataFile, err := data.NewEncryptedFile(fileName, fid, fio.ReadOnlyFIO, v.opts.Encryption)
+	if err != nil {
+		v.report.addProblem(ProblemFileHeader, name, -1, "%v", err)
+		return nil
+	}
+	v.files[fid] = dataFile
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	_, encrypted := dataFile.KeyID()
+	verified := VerifiedFile{Fid: fid, Version: dataFile.Header.Version, Encrypted: encrypted, Size: size}
+	if dataFile.Header.Version > 0 && dataFile.Header.Fid != fid {
+		v.report.addProblem(ProblemFileHeader, name, -1, "file header says file id %d", dataFile.Header.Fid)
+	}
+
+	var offset int64 = 0
+	for {
+		record, recordSize, err1 := dataFile.ReadLogRecord(offset)
+		if err1 != nil {
+			// 只有活跃文件的末尾可能是写了一半的记录，已经写满的文件中读不出来的数据、活跃文件中间的损坏都是损坏
+			kind := ProblemCorruptedRecord
+			if isActive {
+				torn, err2 := isTornTail(dataFile, offset, recordSize, err1)
+				if err2 != nil {
+					return err2
+				}
+				if torn || err1 == io.EOF {
+					kind = ProblemTornTail
+				}
+			}
+			if err1 != io.EOF {
+				v.report.addProblem(kind, name, offset, "%v, %d bytes after it can not be read", err1, size-offset)
+			} else if offset < size {
+				v.report.addProblem(kind, name, offset, "%d bytes of incomplete record at the end of the file", size-offset)
+			}
+			break
+		}
+
+		// 事务的记录要等到读到TransactionFinished标识才算提交完成
+		if _, seqNo := decodeKeyWithSeqNo(record.Key); seqNo != NonTransaction {
+			if record.Type == data.TransactionFinished {
+				delete(txns, seqNo)
+			} else if txn, ok := txns[seqNo]; ok {
+				txn.records++
+			} else {
+				txns[seqNo] = &orphanTxn{records: 1, fid: fid, offset: offset}
+			}
+		}
+		verified.Records++
+		offset += recordSize
+	}
+	verified.ValidSize = offset
+
+	hintName := data.GetDataFileHintName(v.dir, fid)
+	if _, err = os.Stat(hintName); err == nil {
+		verified.HasHint = true
+		if err = v.verifyDataFileHint(dataFile, hintName); err != nil {
+			v.report.addProblem(ProblemHintMismatch, filepath.Base(hintName), -1, "%v", err)
+		} else {
+			verified.HintPassed = true
+		}
+	}
+	v.report.Files = append(v.report.Files, verified)
+	return nil
+}
+
+// verifyDataFileHint 数据文件的hint中每条记录都要指向数据文件中key、类型、长度都相同的记录
+func (v *verifier) verifyDataFileHint(dataFile *data.File, hintName string) error {
+	hintFile, err := data.NewEncryptedFile(hintName, dataFile.Fid, fio.ReadOnlyFIO, v.opts.Encryption)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	header, offset, err := hintFile.ReadLogRecord(0)
+	if err == io.EOF || (err == nil && !bytes.Equal(header.Key, dataFileHintHeaderKey)) {
+		return ErrDataFileHintMismatch
+	}
+	if err != nil {
+		return err
+	}
+	dataSize, err := dataFile.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	if string(header.Value) != strconv.FormatInt(dataSize, 10) {
+		return fmt.Errorf("%w: written for a data file of %s bytes, but it has %d bytes", ErrDataFileHintMismatch, header.Value, dataSize)
+	}
+
+	for {
+		hintRecord, size, err1 := hintFile.ReadLogRecord(offset)
+		if err1 != nil {
+			if err1 == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("offset %d: %w", offset, err1)
+		}
+		pos := data.DecodeLogRecordPos(hintRecord.Value)
+		record, recordSize, err1 := dataFile.ReadLogRecord(pos.Offset)
+		if pos.Fid != dataFile.Fid || err1 != nil || recordSize != int64(pos.Size) ||
+			!bytes.Equal(record.Key, hintRecord.Key) || record.Type != hintRecord.Type {
+			return fmt.Errorf("%w: record at offset %d does not match the data file", ErrDataFileHintMismatch, offset)
+		}
+		offset += size
+	}
+}
+
+func (v *verifier) reportOrphanTxns(txns map[uint64]*orphanTxn) map[uint64]bool {
+	seqNos := make([]uint64, 0, len(txns))
+	for seqNo := range txns {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Slice(seqNos, func(i, j int) bool {
+		return seqNos[i] < seqNos[j]
+	})
+	orphans := make(map[uint64]bool, len(txns))
+	for _, seqNo := range seqNos {
+		txn := txns[seqNo]
+		v.report.addProblem(ProblemOrphanTransaction, filepath.Base(data.GetDataFileName(v.dir, txn.fid)), txn.offset,
+			"transaction %d has %d records but no finish marker", seqNo, txn.records)
+		orphans[seqNo] = true
+	}
+	return orphans
+}
+
+// verifyMergeHint merge生成的hint文件中每条记录都要指向merge过的数据文件中对应的记录
+func (v *verifier) verifyMergeHint() error {
+	hintName := filepath.Join(v.dir, data.HintFileName)
+	if _, err := os.Stat(hintName); err != nil {
+		return nil
+	}
+	// 没有merge完成的标识时hint文件的记录会被数据文件中的记录覆盖（见removeMergeHint），只检查记录本身
+	firstNonMergedFid := uint32(0)
+	if mark, err := v.readMergeFinishedFile(); err != nil {
+		v.report.addProblem(ProblemHintMismatch, data.MergeFinishedFile, -1, "%v", err)
+	} else {
+		firstNonMergedFid = mark
+	}
+
+	hintFile, err := data.NewEncryptedFile(hintName, 0, fio.ReadOnlyFIO, v.opts.Encryption)
+	if err != nil {
+		v.report.addProblem(ProblemHintMismatch, data.HintFileName, -1, "%v", err)
+		return nil
+	}
+	defer hintFile.Close()
+
+	var offset int64 = 0
+	for {
+		hintRecord, size, err1 := hintFile.ReadLogRecord(offset)
+		if err1 != nil {
+			if err1 != io.EOF {
+				v.report.addProblem(ProblemHintMismatch, data.HintFileName, offset, "%v", err1)
+			}
+			return nil
+		}
+		pos := data.DecodeLogRecordPos(hintRecord.Value)
+		if msg := v.checkMergeHintRecord(hintRecord.Key, pos, firstNonMergedFid); msg != "" {
+			v.report.addProblem(ProblemHintMismatch, data.HintFileName, offset, "%s", msg)
+		}
+		offset += size
+	}
+}
+
+func (v *verifier) checkMergeHintRecord(key []byte, pos *data.LogRecordPos, firstNonMergedFid uint32) string {
+	if firstNonMergedFid > 0 && pos.Fid >= firstNonMergedFid {
+		return fmt.Sprintf("points to data file %d which is not merged (first non-merged file is %d)", pos.Fid, firstNonMergedFid)
+	}
+	dataFile, ok := v.files[pos.Fid]
+	if !ok {
+		return fmt.Sprintf("points to data file %d which does not exist or can not be read", pos.Fid)
+	}
+	record, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return fmt.Sprintf("points to data file %d offset %d: %v", pos.Fid, pos.Offset, err)
+	}
+	if realKey, _ := decodeKeyWithSeqNo(record.Key); !bytes.Equal(realKey, key) || size != int64(pos.Size) {
+		return fmt.Sprintf("does not match the record at data file %d offset %d", pos.Fid, pos.Offset)
+	}
+	return ""
+}
+
+// readMergeFinishedFile 读取merge完成的标识中第一个未被merge的文件id，标识不存在时返回0
+func (v *verifier) readMergeFinishedFile() (uint32, error) {
+	fileName := filepath.Join(v.dir, data.MergeFinishedFile)
+	if _, err := os.Stat(fileName); err != nil {
+		return 0, nil
+	}
+	mergeFinishedFile, err := data.NewEncryptedFile(fileName, 0, fio.ReadOnlyFIO, v.opts.Encryption)
+	if err != nil {
+		return 0, err
+	}
+	defer mergeFinishedFile.Close()
+
+	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return 0, err
+	}
+	firstNonMergedFid, err := strconv.ParseUint(string(record.Value), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(firstNonMergedFid), nil
+}
+
+// verifyStaleMerge 报告上次merge留下的merge目录，打开数据库时会根据其中的标识生效或者删除它
+func (v *verifier) verifyStaleMerge() error {
+	mergePath := filepath.Join(path.Dir(path.Clean(v.dir)), path.Base(v.dir)+"-merge")
+	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	msg := "unfinished merge directory, it will be removed on the next open"
+	if _, err := os.Stat(filepath.Join(mergePath, mergeMovingFile)); err == nil {
+		msg = "merge directory whose files were being moved into the data directory, the move will be resumed on the next open"
+	} else if _, err = os.Stat(filepath.Join(mergePath, data.MergeFinishedFile)); err == nil {
+		msg = "finished merge directory which is not installed yet, it will be installed on the next open"
+	}
+	v.report.addProblem(ProblemStaleMerge, mergePath, -1, "%s", msg)
+	return nil
+}
+
+// repair 把每个数据文件中能读出的记录（去掉没有提交完成的事务记录）重写到RepairDir中id相同的文件
+func (v *verifier) repair(fids []uint32, orphans map[uint64]bool) error {
+	repairDir := v.opts.RepairDir
+	if entries, err := os.ReadDir(repairDir); err == nil && len(entries) > 0 {
+		return errors.New("the repair directory is not empty")
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(repairDir, os.ModePerm); err != nil {
+		return err
+	}
+	v.report.RepairDir = repairDir
+
+	for _, fid := range fids {
+		dataFile, ok := v.files[fid]
+		if !ok {
+			continue
+		}
+		repaired, err := data.NewEncryptedFile(data.GetDataFileName(repairDir, fid), fid, fio.StandardFIO, v.opts.Encryption)
+		if err != nil {
+			return err
+		}
+		var offset int64 = 0
+		for {
+			record, size, err1 := dataFile.ReadLogRecord(offset)
+			if err1 != nil {
+				break
+			}
+			offset += size
+			if _, seqNo := decodeKeyWithSeqNo(record.Key); orphans[seqNo] {
+				continue
+			}
+			encoded, _ := repaired.EncodeLogRecord(record)
+			if err = repaired.Write(encoded); err != nil {
+				_ = repaired.Close()
+				return err
+			}
+			v.report.RepairedRecords++
+		}
+		if err = repaired.SyncFile(); err != nil {
+			_ = repaired.Close()
+			return err
+		}
+		if err = repaired.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
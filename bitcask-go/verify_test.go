@@ -0,0 +1,148 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func problemKinds(report *VerifyReport) map[ProblemKind]int {
+	kinds := make(map[ProblemKind]int)
+	for _, problem := range report.Problems {
+		kinds[problem.Kind]++
+	}
+	return kinds
+}
+
+func TestVerify_Clean(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/verify-clean"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileHint = true
+	opts.MergeRatioThreshold = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.PendingPut(utils.GetTestKey(1), utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Close())
+
+	report, err := Verify(opts.DirPath)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.True(t, len(report.Files) > 1)
+	hints := 0
+	for _, file := range report.Files {
+		assert.Equal(t, file.Size, file.ValidSize)
+		if file.HasHint {
+			assert.True(t, file.HintPassed)
+			hints++
+		}
+	}
+	assert.True(t, hints > 0)
+
+	_, err = Verify("/tmp/kv/verify-not-exist")
+	assert.NotNil(t, err)
+}
+
+func TestVerify_Repair(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/verify-repair"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileHint = true
+	repairDir := "/tmp/kv/verify-repaired"
+	defer os.RemoveAll(repairDir)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	// 没有提交完成的事务
+	for i := 1000; i < 1003; i++ {
+		_, err = db.appendLogRecordWithLock(&data.LogRecord{Key: encodeKeyWithSeqNo(utils.GetTestKey(i), 100), Value: utils.GetTestKey(i)})
+		assert.Nil(t, err)
+	}
+	fid := corruptRecord(t, db, utils.GetTestKey(100), flipLastByte)
+	activeFid := db.activeFile.Fid
+	assert.Nil(t, db.Close())
+	appendToFile(t, data.GetDataFileName(opts.DirPath, activeFid), make([]byte, 10))
+	// hint文件对应的数据文件不存在；上次merge留下的目录
+	assert.Nil(t, os.WriteFile(data.GetDataFileHintName(opts.DirPath, 1000), nil, 0644))
+	assert.Nil(t, os.MkdirAll(opts.DirPath+"-merge", os.ModePerm))
+	defer os.RemoveAll(opts.DirPath + "-merge")
+
+	report, err := VerifyWithOptions(opts.DirPath, VerifyOptions{RepairDir: repairDir})
+	assert.Nil(t, err)
+	kinds := problemKinds(report)
+	assert.Equal(t, 1, kinds[ProblemCorruptedRecord])
+	assert.Equal(t, 1, kinds[ProblemTornTail])
+	assert.Equal(t, 1, kinds[ProblemOrphanTransaction])
+	assert.Equal(t, 1, kinds[ProblemStaleMerge])
+	assert.True(t, kinds[ProblemHintMismatch] >= 1)
+	for _, problem := range report.Problems {
+		if problem.Kind == ProblemCorruptedRecord {
+			assert.Equal(t, data.GetDataFileName("", fid), problem.File)
+		}
+	}
+
+	// 修复后的目录可以直接打开，损坏处之前的数据都在，没有提交的事务被去掉
+	report, err = Verify(repairDir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	repaired, err := Open(Options{DirPath: repairDir, DataFileSize: opts.DataFileSize, IndexType: opts.IndexType})
+	assert.Nil(t, err)
+	defer repaired.Close()
+	for i := 0; i < 100; i++ {
+		val, err := repaired.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	_, err = repaired.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 修复的目标目录必须为空
+	_, err = VerifyWithOptions(opts.DirPath, VerifyOptions{RepairDir: repairDir})
+	assert.NotNil(t, err)
+}
+
+func TestVerify_SealedFileTail(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/tmp/kv/verify-sealed-tail"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileHint = false
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	activeFid := db.activeFile.Fid
+	assert.True(t, activeFid > 1)
+	assert.Nil(t, db.Close())
+
+	// 写满的文件末尾多出来的几个字节不是没写完的记录，而是损坏
+	appendToFile(t, data.GetDataFileName(opts.DirPath, 1), []byte{1, 2, 3})
+	appendToFile(t, data.GetDataFileName(opts.DirPath, activeFid), []byte{1, 2, 3})
+	report, err := Verify(opts.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Problems))
+	for _, problem := range report.Problems {
+		if problem.File == data.GetDataFileName("", 1) {
+			assert.Equal(t, ProblemCorruptedRecord, problem.Kind)
+		} else {
+			assert.Equal(t, data.GetDataFileName("", activeFid), problem.File)
+			assert.Equal(t, ProblemTornTail, problem.Kind)
+		}
+	}
+}